@@ -0,0 +1,51 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	beegfs "github.com/netapp/beegfs-csi-driver/pkg/beegfs"
+)
+
+// configCheck implements "beegfs-csi-driver config check". It prints the configuration a node would use for a BeeGFS
+// file system and returns the process exit code.
+func configCheck(args []string) int {
+	flags := flag.NewFlagSet("config check", flag.ContinueOnError)
+	configPath := flags.String("config-path", "", "path to plugin configuration file")
+	nodeID := flags.String("node-id", "", "node id to apply nodeSpecificConfigs for")
+	nodeLabels := flags.String("node-labels", "", "comma separated key=value node labels to apply nodeSpecificConfigs for")
+	nodeLabelsFile := flags.String("node-labels-file", "", "path to a file with one key=value node label per line")
+	sysMgmtdHost := flags.String("sys-mgmtd-host", "", "sysMgmtdHost of the BeeGFS file system to apply fileSystemSpecificConfigs for")
+	fsName := flags.String("fs-name", "", "name of the BeeGFS file system in fileSystems (instead of --sys-mgmtd-host)")
+	clientConfTemplatePath := flags.String("client-conf-template-path", "/etc/beegfs/beegfs-client.conf", "path to template beegfs-client.conf")
+	resolveInterfaces := flags.Bool("resolve-interfaces", false, "resolve connInterfacesSelectors against the network interfaces of this machine (run inside the node's csi-beegfs-node Pod)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	fileSystem := *sysMgmtdHost
+	if *fsName != "" {
+		if *sysMgmtdHost != "" {
+			fmt.Fprintln(os.Stderr, "Only one of --fs-name and --sys-mgmtd-host can be provided")
+			return 2
+		}
+		fileSystem = *fsName
+	}
+	labels, err := beegfs.LoadNodeLabels(*nodeLabels, *nodeLabelsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration check failed: %v\n", err)
+		return 1
+	}
+	if err := beegfs.CheckConfig(os.Stdout, *configPath, *nodeID, fileSystem, *clientConfTemplatePath,
+		labels, *resolveInterfaces); err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration check failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
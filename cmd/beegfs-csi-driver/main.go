@@ -24,26 +24,34 @@ package main
 import (
 	"flag"
 	"fmt"
-	"github.com/golang/glog"
-	"k8s.io/klog/v2"
 	"os"
+	"os/signal"
 	"path"
+	"syscall"
+
+	"k8s.io/klog/v2"
 
 	beegfs "github.com/netapp/beegfs-csi-driver/pkg/beegfs"
 )
 
 func init() {
-	if err := flag.Set("logtostderr", "true"); err != nil {
-		glog.Fatalf("Failed to set glog flag logtostderr=true: %v", err.Error())
-	}
+	klog.InitFlags(nil) // klog logs to stderr by default
 }
 
 var (
 	configPath             = flag.String("config-path", "", "path to plugin configuration file")
-	csDataDir              = flag.String("cs-data-dir", "/tmp/beegfs-csi-data-dir", "path to directory the controller service uses to store client configuration files and mount file systems")
+	csDataDir              = flag.String("cs-data-dir", "/tmp/beegfs-csi-data-dir", "path to directory the controller service (and the node service for ephemeral volumes) uses to store client configuration files and mount file systems")
+	csDataDirSweep         = flag.String("cs-data-dir-sweep", beegfs.CsDataDirSweepDisabled, "whether to clean up stale mounts and directories left in cs-data-dir by the controller service at startup (disabled, dry-run, or enabled)")
 	driverName             = flag.String("driver-name", "beegfs.csi.netapp.com", "name of the driver")
 	endpoint               = flag.String("endpoint", "unix://tmp/csi.sock", "CSI endpoint")
+	logFormat              = flag.String("log-format", beegfs.LogFormatText, "format of log output (text or json)")
+	metricsAddress         = flag.String("metrics-address", "", "address (e.g. :9090) at which to serve Prometheus metrics at /metrics; metrics are not served if empty")
 	nodeID                 = flag.String("node-id", "", "node id")
+	nodeLabels             = flag.String("node-labels", "", "comma separated key=value labels nodeSpecificConfigs can select this node by")
+	nodeLabelsFile         = flag.String("node-labels-file", "", "path to a file with one key=value label per line that nodeSpecificConfigs can select this node by")
+	tracingExporter        = flag.String("tracing-exporter", beegfs.TracingExporterNone, "exporter for OpenTelemetry traces (none, otlp, stdout, or file)")
+	tracingOTLPEndpoint    = flag.String("tracing-otlp-endpoint", "localhost:4317", "address of the OpenTelemetry collector traces are exported to with the otlp exporter")
+	tracingFilePath        = flag.String("tracing-file-path", "/tmp/beegfs-csi-traces.json", "path to the file traces are appended to with the file exporter")
 	showVersion            = flag.Bool("version", false, "Show version.")
 	clientConfTemplatePath = flag.String("client-conf-template-path", "/etc/beegfs/beegfs-client.conf", "path to template beegfs-client.conf")
 
@@ -52,25 +60,11 @@ var (
 )
 
 func main() {
-	flag.Parse()
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "check" {
+		os.Exit(configCheck(os.Args[3:]))
+	}
 
-	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
-	klog.InitFlags(klogFlags)
-
-	// This block is almost identical to
-	// https://github.com/kubernetes/klog/blob/v2.5.0/examples/coexist_glog/coexist_glog.go, which was released under
-	// the Apache 2.0 license. The only modification is to the first if statement.
-	// Sync the glog and klog flags.
-	flag.CommandLine.VisitAll(func(f1 *flag.Flag) {
-		f2 := klogFlags.Lookup(f1.Name)
-		if f2 != nil && f1.Value.String() != f2.DefValue {
-			value := f1.Value.String()
-			if err := f2.Value.Set(value); err != nil {
-				glog.Fatalf("Failed to set klog flag %s to %s: %s", f2.Name, value, err.Error())
-			}
-		}
-	})
-	// end of code taken from klog example
+	flag.Parse()
 
 	if *showVersion {
 		baseName := path.Base(os.Args[0])
@@ -78,14 +72,45 @@ func main() {
 		return
 	}
 
+	if err := beegfs.InitLogging(*logFormat); err != nil {
+		klog.Fatalf("Failed to initialize logging: %s", err.Error())
+	}
 	handle()
+	klog.Flush()
 	os.Exit(0)
 }
 
 func handle() {
-	driver, err := beegfs.NewBeegfsDriver(*configPath, *csDataDir, *driverName, *endpoint, *nodeID, *clientConfTemplatePath, version)
+	labels, err := beegfs.LoadNodeLabels(*nodeLabels, *nodeLabelsFile)
+	if err != nil {
+		klog.Fatalf("Failed to load node labels: %s", err.Error())
+	}
+	driver, err := beegfs.NewBeegfsDriver(*configPath, *csDataDir, *csDataDirSweep, *driverName, *endpoint, *nodeID, *clientConfTemplatePath, version, labels)
+	if err != nil {
+		klog.Fatalf("Failed to initialize driver: %s", err.Error()) // exits with code 255
+	}
+	shutdownTracing, err := beegfs.InitTracing(*tracingExporter, *tracingOTLPEndpoint, *tracingFilePath)
 	if err != nil {
-		glog.Fatalf("Failed to initialize driver: %s", err.Error()) // exits with code 255
+		klog.Fatalf("Failed to initialize tracing: %s", err.Error())
+	}
+	if *metricsAddress != "" {
+		go func() {
+			if err := beegfs.ServeMetrics(*metricsAddress); err != nil {
+				klog.Fatalf("Failed to serve metrics: %s", err.Error())
+			}
+		}()
 	}
-	driver.Run()
+
+	// Kubernetes sends SIGTERM when it stops the container. Stop the driver gracefully so that in-flight RPCs can
+	// finish and flush any buffered spans before exiting.
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		klog.InfoS("Received signal, shutting down", "signal", sig.String())
+		close(stop)
+	}()
+	driver.Run(stop)
+	shutdownTracing()
 }
@@ -23,21 +23,38 @@ package beegfs
 
 import (
 	"path"
+	"strings"
+	"time"
 
-	"github.com/golang/glog"
 	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
 	"k8s.io/utils/mount"
 )
 
 const (
 	volDirBasePathKey          = "volDirBasePath"
 	sysMgmtdHostKey            = "sysMgmtdHost"
+	fsNameKey                  = "fsName"
 	storagePoolIDKey           = "stripePattern/storagePoolID"
 	stripePatternChunkSizeKey  = "stripePattern/chunkSize"
 	stripePatternNumTargetsKey = "stripePattern/numTargets"
+	ephemeralKey               = "csi.storage.k8s.io/ephemeral" // set in the volume context of ephemeral volumes
+	connAuthSecretKey          = "connAuth"                     // key of the connAuthFile contents in CSI secrets
+	beegfsClientConfKeyPrefix  = "beegfsClientConf/"            // prefix of per volume beegfsClientConf parameters
 
-	LogDebug   = glog.Level(3) // This log level is used for most informational logs in RPCs and GRPC calls
-	LogVerbose = glog.Level(5) // This log level is used for only very repetitive logs such as the Probe GRPC call
+	ephemeralDirName = "ephemeral" // subdirectory of csDataDir the node service uses for ephemeral volumes
+
+	// Valid values for the csDataDirSweep parameter of NewBeegfsDriver.
+	CsDataDirSweepDisabled = "disabled" // do not clean up csDataDir at startup
+	CsDataDirSweepDryRun   = "dry-run"  // only log what would be cleaned up in csDataDir at startup
+	CsDataDirSweepEnabled  = "enabled"  // clean up stale mounts and directories in csDataDir at startup
+
+	// gracefulStopTimeout is how long Run waits for in-flight RPCs to finish after it is asked to stop. It is shorter
+	// than the default Kubernetes termination grace period, so that there is time left to flush traces.
+	gracefulStopTimeout = 20 * time.Second
+
+	LogDebug   = klog.Level(3) // This log level is used for most informational logs in RPCs and GRPC calls
+	LogVerbose = klog.Level(5) // This log level is used for only very repetitive logs such as the Probe GRPC call
 )
 
 type beegfs struct {
@@ -45,9 +62,11 @@ type beegfs struct {
 	nodeID                 string
 	version                string
 	endpoint               string
-	pluginConfig           pluginConfig
+	configStore            *pluginConfigStore
+	configWatcher          *configWatcher // nil if there is no configuration file
 	clientConfTemplatePath string
 	csDataDir              string // directory controller service uses to create BeeGFS config files and mount file systems
+	csDataDirSweep         string // one of CsDataDirSweepDisabled, CsDataDirSweepDryRun, or CsDataDirSweepEnabled
 
 	ids *identityServer
 	ns  *nodeServer
@@ -68,6 +87,9 @@ type beegfs struct {
 //            |-- "connInterfacesFile"
 //            |-- "connNetFilterFile"
 //            |-- "connTcpOnlyFilterFile"
+//            |-- "connAuthFile"
+//            |-- "volumeID" (ephemeral volumes only)
+//            |-- "volumeContext" (ephemeral volumes only)
 //            |-- "mount" (mountPath)
 //                |-- ...
 //                    |-- volDirBasePath
@@ -84,11 +106,14 @@ type beegfsVolume struct {
 	mountDirPath             string // absolute path to directory containing configuration files and mount point from node root
 	mountPath                string // absolute path to mount point from host root (e.g. .../mountDirPath/mount)
 	sysMgmtdHost             string // IP address or hostname of BeeGFS mgmtd service
+	fsName                   string // name of the BeeGFS file system in the configuration's fileSystems (may be empty)
 	volDirBasePathBeegfsRoot string // absolute path to BeeGFS parent directory from BeeGFS root (e.g. /parent)
 	volDirBasePath           string // absolute path to BeeGFS parent directory from host root (e.g. ../mountDirPath/mount/parent)
 	volDirPathBeegfsRoot     string // absolute path to BeeGFS directory from BeeGFS root (e.g. /parent/volume)
 	volDirPath               string // absolute path to BeeGFS directory from host root (e.g. .../mountDirPath/mount/parent/volume)
-	volumeID                 string // like beegfs://sysMgmtdHost/volDirPathBeegfsRoot
+	volumeID                 string // like beegfs://fsName/volDirPathBeegfsRoot or beegfs://sysMgmtdHost/volDirPathBeegfsRoot
+	connAuth                 []byte // connAuthFile contents from CSI secrets (overrides config.ConnAuthFile; NEVER log)
+	transport                string // transport requested by the StorageClass (see applyTransport); empty for any
 }
 
 type stripePatternConfig struct {
@@ -101,7 +126,8 @@ var (
 	vendorVersion = "dev"
 )
 
-func NewBeegfsDriver(configPath, csDataDir, driverName, endpoint, nodeID, clientConfTemplatePath, version string) (*beegfs, error) {
+func NewBeegfsDriver(configPath, csDataDir, csDataDirSweep, driverName, endpoint, nodeID, clientConfTemplatePath,
+	version string, nodeLabels map[string]string) (*beegfs, error) {
 	if driverName == "" {
 		return nil, errors.New("no driver name provided")
 	}
@@ -113,24 +139,37 @@ func NewBeegfsDriver(configPath, csDataDir, driverName, endpoint, nodeID, client
 	if endpoint == "" {
 		return nil, errors.New("no driver endpoint provided")
 	}
+	switch csDataDirSweep {
+	case "":
+		csDataDirSweep = CsDataDirSweepDisabled
+	case CsDataDirSweepDisabled, CsDataDirSweepDryRun, CsDataDirSweepEnabled:
+	default:
+		return nil, errors.Errorf("invalid csDataDir sweep mode %s", csDataDirSweep)
+	}
 	if version != "" {
 		vendorVersion = version
 	}
 
 	var pluginConfig pluginConfig
+	var rawConfigBytes []byte
 	if configPath != "" {
 		var err error
-		if pluginConfig, err = parseConfigFromFile(configPath, nodeID); err != nil {
+		if rawConfigBytes, err = fsutil.ReadFile(configPath); err != nil {
+			return nil, errors.Wrap(err, "failed to read configuration file")
+		}
+		if pluginConfig, err = parseConfig(rawConfigBytes, nodeID, nodeLabels); err != nil {
 			return nil, errors.WithMessage(err, "failed to handle configuration file")
 		}
+		if err = validateClientConf(pluginConfig, clientConfTemplatePath); err != nil {
+			return nil, errors.WithMessage(err, "configuration file is incompatible with beegfs-client.conf template")
+		}
 	}
 
 	if err := fs.MkdirAll(csDataDir, 0750); err != nil {
 		return nil, errors.Wrap(err, "failed to create csDataDir")
 	}
 
-	glog.Infof("Driver: %v ", driverName)
-	glog.Infof("Version: %s", vendorVersion)
+	klog.InfoS("Initializing driver", "driver", driverName, "version", vendorVersion)
 
 	var driver beegfs
 	driver = beegfs{
@@ -138,20 +177,30 @@ func NewBeegfsDriver(configPath, csDataDir, driverName, endpoint, nodeID, client
 		version:                vendorVersion,
 		nodeID:                 nodeID,
 		endpoint:               endpoint,
-		pluginConfig:           pluginConfig,
+		configStore:            newPluginConfigStore(pluginConfig),
 		clientConfTemplatePath: clientConfTemplatePath,
 		csDataDir:              csDataDir,
+		csDataDirSweep:         csDataDirSweep,
+	}
+
+	if configPath != "" {
+		driver.configWatcher = newConfigWatcher(configPath, nodeID, clientConfTemplatePath, nodeLabels, driver.configStore,
+			rawConfigBytes)
 	}
 
 	// Create GRPC servers
 	driver.ids = NewIdentityServer(driver.driverName, driver.version)
-	driver.ns = NewNodeServer(driver.nodeID, driver.pluginConfig, driver.clientConfTemplatePath)
-	driver.cs = NewControllerServer(driver.nodeID, driver.pluginConfig, driver.clientConfTemplatePath, driver.csDataDir)
+	driver.ids.prober = newReadinessProber(readinessCheckInterval, driver.readinessChecks())
+	driver.ns = NewNodeServer(driver.nodeID, driver.configStore, driver.clientConfTemplatePath,
+		path.Join(driver.csDataDir, ephemeralDirName))
+	driver.cs = NewControllerServer(driver.nodeID, driver.configStore, driver.clientConfTemplatePath, driver.csDataDir)
 
 	return &driver, nil
 }
 
-func (b *beegfs) Run() {
+// Run serves the driver's services until stop is closed. It then stops accepting RPCs, waits up to
+// gracefulStopTimeout for in-flight RPCs to finish, and returns.
+func (b *beegfs) Run(stop <-chan struct{}) {
 	if b.cs.mounter == nil {
 		b.cs.mounter = mount.New("")
 	}
@@ -159,36 +208,137 @@ func (b *beegfs) Run() {
 		b.ns.mounter = mount.New("")
 	}
 
+	if b.csDataDirSweep != CsDataDirSweepDisabled {
+		dryRun := b.csDataDirSweep == CsDataDirSweepDryRun
+		if result, err := b.cs.sweepDataDir(dryRun); err != nil {
+			klog.ErrorS(err, "Failed to clean up csDataDir", "path", b.csDataDir)
+		} else {
+			observeDataDirSweep(result, dryRun)
+			klog.InfoS("Cleaned up csDataDir", "path", b.csDataDir, "dry_run", dryRun, "unmounted", result.unmounted,
+				"removed", result.removed, "failed", result.failed)
+		}
+	}
+	if err := b.ns.restoreStagedMounts(); err != nil {
+		klog.ErrorS(err, "Failed to restore staged BeeGFS file systems")
+	}
+
+	if b.ids.prober != nil {
+		go b.ids.prober.run()
+	}
+	if b.configWatcher != nil {
+		go b.configWatcher.run()
+	}
+
 	s := NewNonBlockingGRPCServer()
 	s.Start(b.endpoint, b.ids, b.cs, b.ns)
+	go func() {
+		<-stop
+		klog.InfoS("Stopping gRPC server", "timeout", gracefulStopTimeout)
+		stopped := make(chan struct{})
+		go func() {
+			s.Stop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(gracefulStopTimeout):
+			klog.InfoS("Timed out waiting for in-flight RPCs to finish", "timeout", gracefulStopTimeout)
+			s.ForceStop()
+		}
+	}()
 	s.Wait()
 }
 
-// newBeeGFSVolume creates a beegfsVolume from parameters.
-func newBeegfsVolume(mountDirPath, sysMgmtdHost, volDirPathBeegfsRoot string, pluginConfig pluginConfig) beegfsVolume {
+// newBeeGFSVolume creates a beegfsVolume from parameters. fileSystem is either the name of a file system in
+// pluginConfig's fileSystems or a sysMgmtdHost (see resolveFileSystem). It is used as is in the volume's volumeID, so
+// the volumeID of an existing volume does not change when its file system is renamed or remapped. volBeegfsClientConf
+// (see getBeegfsClientConfFromParams) is layered on top of the beegfsClientConf pluginConfig specifies for the file
+// system.
+func newBeegfsVolume(mountDirPath, fileSystem, volDirPathBeegfsRoot string, pluginConfig pluginConfig,
+	volBeegfsClientConf map[string]string) beegfsVolume {
 	// These parameters must be constructed outside of the struct literal.
 	mountPath := path.Join(mountDirPath, "mount")
 	volDirPath := path.Join(mountPath, volDirPathBeegfsRoot)
+	sysMgmtdHost, fsName := pluginConfig.resolveFileSystem(fileSystem)
+	config := squashConfigForSysMgmtdHost(sysMgmtdHost, pluginConfig)
+	for k, v := range volBeegfsClientConf {
+		config.BeegfsClientConf[k] = v
+	}
 
 	return beegfsVolume{
-		config:                   squashConfigForSysMgmtdHost(sysMgmtdHost, pluginConfig),
+		config:                   config,
 		clientConfPath:           path.Join(mountDirPath, "beegfs-client.conf"),
 		mountDirPath:             mountDirPath,
 		mountPath:                mountPath,
 		sysMgmtdHost:             sysMgmtdHost,
+		fsName:                   fsName,
 		volDirBasePathBeegfsRoot: path.Dir(volDirPathBeegfsRoot),
 		volDirBasePath:           path.Dir(volDirPath),
 		volDirPathBeegfsRoot:     volDirPathBeegfsRoot,
 		volDirPath:               volDirPath,
-		volumeID:                 newBeegfsUrl(sysMgmtdHost, volDirPathBeegfsRoot),
+		volumeID:                 newBeegfsUrl(fileSystem, volDirPathBeegfsRoot),
 	}
 }
 
-// newBeeGFSVolume creates a beegfsVolume from a volumeID.
-func newBeegfsVolumeFromID(mountDirPath, volumeID string, pluginConfig pluginConfig) (beegfsVolume, error) {
-	sysMgmtdHost, volDirPathBeegfsRoot, err := parseBeegfsUrl(volumeID)
+// newBeeGFSVolume creates a beegfsVolume from a volumeID. Both volumeIDs that refer to a named file system and
+// (legacy) volumeIDs that refer to a sysMgmtdHost are accepted.
+func newBeegfsVolumeFromID(mountDirPath, volumeID string, pluginConfig pluginConfig,
+	volBeegfsClientConf map[string]string) (beegfsVolume, error) {
+	fileSystem, volDirPathBeegfsRoot, err := parseBeegfsUrl(volumeID)
 	if err != nil {
 		return beegfsVolume{}, err
 	}
-	return newBeegfsVolume(mountDirPath, sysMgmtdHost, volDirPathBeegfsRoot, pluginConfig), nil
+	return newBeegfsVolume(mountDirPath, fileSystem, volDirPathBeegfsRoot, pluginConfig, volBeegfsClientConf), nil
+}
+
+// getFileSystemFromParams returns the file system (a file system name or a sysMgmtdHost) StorageClass parameters or an
+// ephemeral volume's attributes refer to. Exactly one of fsName and sysMgmtdHost must be provided and fsName must be
+// the name of a file system in pluginConfig's fileSystems or a valid sysMgmtdHost (see isValidSysMgmtdHost).
+func getFileSystemFromParams(params map[string]string, pluginConfig pluginConfig) (string, error) {
+	fsName, hasFSName := params[fsNameKey]
+	sysMgmtdHost, hasSysMgmtdHost := params[sysMgmtdHostKey]
+	switch {
+	case hasFSName && hasSysMgmtdHost:
+		return "", errors.Errorf("only one of %s and %s can be provided", fsNameKey, sysMgmtdHostKey)
+	case hasFSName:
+		if _, ok := pluginConfig.lookupFileSystem(fsName); !ok {
+			return "", errors.Errorf("file system %s is not defined in the configuration's fileSystems", fsName)
+		}
+		return fsName, nil
+	case hasSysMgmtdHost:
+		if !isValidSysMgmtdHost(sysMgmtdHost) {
+			return "", errors.Errorf("invalid %s %s", sysMgmtdHostKey, sysMgmtdHost)
+		}
+		return sysMgmtdHost, nil
+	default:
+		return "", errors.Errorf("%s or %s not provided", fsNameKey, sysMgmtdHostKey)
+	}
+}
+
+// getBeegfsClientConfFromParams returns the beegfsClientConf parameters that StorageClass parameters, a volume's
+// context, or an ephemeral volume's attributes override for a single volume (e.g. beegfsClientConf/tuneFileCacheType).
+// Only parameters in pluginConfig's allowedVolumeBeegfsClientConf may be overridden and the values of known parameters
+// are validated (see validateClientConfValue).
+func getBeegfsClientConfFromParams(params map[string]string, pluginConfig pluginConfig) (map[string]string, error) {
+	var volBeegfsClientConf map[string]string
+	for key, value := range params {
+		if !strings.HasPrefix(key, beegfsClientConfKeyPrefix) {
+			continue
+		}
+		confKey := strings.TrimPrefix(key, beegfsClientConfKeyPrefix)
+		if !containsString(pluginConfig.AllowedVolumeBeegfsClientConf, confKey) {
+			return nil, errors.Errorf("beegfsClientConf parameter %s is not allowed in volume parameters", confKey)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, errors.Errorf("invalid value for beegfsClientConf parameter %s", confKey)
+		}
+		if err := validateClientConfValue(confKey, value); err != nil {
+			return nil, err
+		}
+		if volBeegfsClientConf == nil {
+			volBeegfsClientConf = make(map[string]string)
+		}
+		volBeegfsClientConf[confKey] = value
+	}
+	return volBeegfsClientConf, nil
 }
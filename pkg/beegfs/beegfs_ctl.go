@@ -8,20 +8,26 @@ package beegfs
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/golang/glog"
 	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/context"
 )
 
 // beegfsCtlExecutorInterface abstracts beegfs-ctl so tests can run without access to a beegfs-ctl binary or a BeeGFS
 // file system.
 type beegfsCtlExecutorInterface interface {
-	createDirectoryForVolume(vol beegfsVolume) error
-	statDirectoryForVolume(vol beegfsVolume) (string, error)
-	setPatternForVolume(vol beegfsVolume, config stripePatternConfig) error
+	createDirectoryForVolume(ctx context.Context, vol beegfsVolume) error
+	statDirectoryForVolume(ctx context.Context, vol beegfsVolume) (string, error)
+	setPatternForVolume(ctx context.Context, vol beegfsVolume, config stripePatternConfig) error
+	checkAvailable() error
 }
 
 // beegfsCtlExecutor is the standard implementation of beegfsCtlExecutorInterface.
@@ -30,13 +36,14 @@ type beegfsCtlExecutor struct{}
 // createDirectoryForVolume uses a "beegfs-ctl --createdir" command to create the directory specified by
 // vol.volDirPathBeegfsRoot on the BeeGFS file system specified by vol.sysMgmtdHost. createDirectory returns an error
 // if it cannot create the directory, but does not return an error if the directory already exists.
-func (ctlExec *beegfsCtlExecutor) createDirectoryForVolume(vol beegfsVolume) error {
-	glog.V(LogDebug).Infof("Creating BeeGFS directory %s for %s", vol.volDirPathBeegfsRoot, vol.volumeID)
+func (ctlExec *beegfsCtlExecutor) createDirectoryForVolume(ctx context.Context, vol beegfsVolume) error {
+	log := newVolumeLogger(ctx, vol).WithValues("path", vol.volDirPathBeegfsRoot)
+	log.V(LogDebug).Info("Creating BeeGFS directory")
 	// Check if volume already exists.
-	_, err := ctlExec.statDirectoryForVolume(vol)
+	_, err := ctlExec.statDirectoryForVolume(ctx, vol)
 	if errors.As(err, &ctlNotExistError{}) {
 		// We can't find the volume so we need to create one.
-		glog.V(LogDebug).Infof("BeeGFS directory %s does not exist for %s", vol.volDirPathBeegfsRoot, vol.volumeID)
+		log.V(LogDebug).Info("BeeGFS directory does not exist")
 
 		// Create parent directories if necessary.
 		// Create a slice of paths where the first path is the most general and each subsequent path is less general.
@@ -49,7 +56,7 @@ func (ctlExec *beegfsCtlExecutor) createDirectoryForVolume(vol beegfsVolume) err
 		for _, dir := range dirsToMake {
 			// TODO(eastburj, A119): Consider replacing "--access=0777" with "fsGroup support"[1].
 			//   [1](https://kubernetes-csi.github.io/docs/support-fsgroup.html)
-			_, err := ctlExec.execute(vol.clientConfPath, []string{"--unmounted", "--createdir", "--access=0777", dir})
+			_, err := ctlExec.execute(ctx, vol.clientConfPath, []string{"--unmounted", "--createdir", "--access=0777", dir})
 			if err != nil && !errors.As(err, &ctlExistError{}) {
 				// We can't create the volume.
 				return errors.Wrapf(err, "cannot create BeeGFS directory %s for %s", dir, vol.volumeID)
@@ -58,15 +65,15 @@ func (ctlExec *beegfsCtlExecutor) createDirectoryForVolume(vol beegfsVolume) err
 	} else if err != nil {
 		return err
 	} else {
-		glog.V(LogDebug).Infof("BeeGFS directory %s already exists for %s", vol.volDirPathBeegfsRoot, vol.volumeID)
+		log.V(LogDebug).Info("BeeGFS directory already exists")
 	}
 	return nil
 }
 
 // statDirectoryForVolume returns the information output by "beegfs-ctl --getentryinfo" as a string, or an empty string
 // and an error if the stat fails.
-func (ctlExec *beegfsCtlExecutor) statDirectoryForVolume(vol beegfsVolume) (string, error) {
-	return ctlExec.execute(vol.clientConfPath, []string{"--unmounted", "--getentryinfo", vol.volDirPathBeegfsRoot})
+func (ctlExec *beegfsCtlExecutor) statDirectoryForVolume(ctx context.Context, vol beegfsVolume) (string, error) {
+	return ctlExec.execute(ctx, vol.clientConfPath, []string{"--unmounted", "--getentryinfo", vol.volDirPathBeegfsRoot})
 }
 
 // constructSetPatternForVolume builds the arguments passed to setPatternForVolume.
@@ -97,11 +104,12 @@ func constructSetPatternForVolume(config stripePatternConfig) ([]string, bool) {
 // vol.volDirPathBeegfsRoot on the BeeGFS file system. setPatternForVolume returns an error if it cannot set the pattern for
 // the directory, but does not return an error if the pattern on the directory already exists. setPatternForVolume has no
 // effect and does not return an error if config is empty.
-func (ctlExec *beegfsCtlExecutor) setPatternForVolume(vol beegfsVolume, config stripePatternConfig) error {
+func (ctlExec *beegfsCtlExecutor) setPatternForVolume(ctx context.Context, vol beegfsVolume,
+	config stripePatternConfig) error {
 	args, needToExecute := constructSetPatternForVolume(config)
 	if needToExecute {
 		args = append(args, vol.volDirPathBeegfsRoot)
-		_, err := ctlExec.execute(vol.clientConfPath, args)
+		_, err := ctlExec.execute(ctx, vol.clientConfPath, args)
 		if err != nil {
 			return errors.WithMessagef(err, "cannot set pattern for BeeGFS directory %s for volume %s", vol.volDirPathBeegfsRoot, vol.sysMgmtdHost)
 		}
@@ -110,19 +118,75 @@ func (ctlExec *beegfsCtlExecutor) setPatternForVolume(vol beegfsVolume, config s
 	return nil
 }
 
+// chwrapHostRoot is where the driver's container image mounts the host's root file system. chwrapBinaryDirs are the
+// directories (relative to the host's root) chwrap searches for a binary, in order.
+const chwrapHostRoot = "/host"
+
+var chwrapBinaryDirs = []string{"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin"}
+
+// checkAvailable returns an error if beegfs-ctl is not installed. In the driver's container image beegfs-ctl is a
+// symlink to chwrap, which executes the beegfs-ctl installed on the host, so checkAvailable looks for it on the host
+// the same way chwrap does.
+func (*beegfsCtlExecutor) checkAvailable() error {
+	ctlPath, err := exec.LookPath("beegfs-ctl")
+	if err != nil {
+		return errors.Wrap(err, "beegfs-ctl not found")
+	}
+	target, err := filepath.EvalSymlinks(ctlPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve %s", ctlPath)
+	}
+	if path.Base(target) != "chwrap" {
+		return nil
+	}
+	if findHostBinary(chwrapHostRoot, "beegfs-ctl") == "" {
+		return errors.Errorf("beegfs-ctl not found on the host in any of %v", chwrapBinaryDirs)
+	}
+	return nil
+}
+
+// findHostBinary returns the path (relative to hostRoot) of the first readable and executable regular file or symlink
+// named binary in one of chwrapBinaryDirs, or an empty string if there is none. Like chwrap, it does not follow
+// symlinks, as an absolute symlink on the host does not resolve correctly outside of a chroot.
+func findHostBinary(hostRoot, binary string) string {
+	for _, dir := range chwrapBinaryDirs {
+		binaryPath := path.Join(dir, binary)
+		var info os.FileInfo
+		var err error
+		if lstater, ok := fs.(afero.Lstater); ok {
+			info, _, err = lstater.LstatIfPossible(path.Join(hostRoot, binaryPath))
+		} else {
+			info, err = fs.Stat(path.Join(hostRoot, binaryPath))
+		}
+		if err != nil {
+			continue
+		}
+		mode := info.Mode()
+		if (mode.IsRegular() || mode&os.ModeSymlink != 0) && mode&0500 == 0500 {
+			return binaryPath
+		}
+	}
+	return ""
+}
+
 // execute runs arbitrary beegfs-ctl commands like "beegfs-ctl --arg1 --arg2=value". It logs the stdout and stderr
-// when running at a high verbosity and returns stdout as a string (as well as any potential errors). execute fails if
-// beegfs-ctl is not on the PATH.
-func (*beegfsCtlExecutor) execute(clientConfPath string, args []string) (stdOut string, err error) {
+// when running at a high verbosity and returns stdout as a string (as well as any potential errors). Sensitive values
+// (see redactText) are masked in logged output and errors, but not in the returned stdout. execute fails if beegfs-ctl
+// is not on the PATH.
+func (*beegfsCtlExecutor) execute(ctx context.Context, clientConfPath string, args []string) (stdOut string, err error) {
 	args = append([]string{fmt.Sprintf("--cfgFile=%s", clientConfPath)}, args...)
+	_, span := startSpan(ctx, "beegfs-ctl "+beegfsCtlSubcommand(args), attribute.Array("beegfs.ctl.args", args))
+	defer func() { endSpan(span, err) }()
 	cmd := exec.Command("beegfs-ctl", args...)
-	glog.V(LogDebug).Infof("Executing command: %s", cmd.Args)
+	log := newLogger(ctx).WithValues("args", cmd.Args)
+	log.V(LogDebug).Info("Executing command")
 
 	var stdoutBuffer bytes.Buffer
 	var stderrBuffer bytes.Buffer
 	cmd.Stdout = &stdoutBuffer
 	cmd.Stderr = &stderrBuffer
 
+	start := time.Now()
 	err = cmd.Run()
 	stdOutString := stdoutBuffer.String()
 	stdErrString := stderrBuffer.String()
@@ -132,15 +196,13 @@ func (*beegfsCtlExecutor) execute(clientConfPath string, args []string) (stdOut
 		} else if strings.Contains(stdErrString, "exists already") {
 			err = errors.WithStack(newCtlExistError(stdOutString, stdErrString))
 		} else {
-			err = errors.Wrapf(err, "beegfs-ctl failed with stdOut: %s and stdErr: %s", stdOutString, stdErrString)
+			err = errors.Wrapf(err, "beegfs-ctl failed with stdOut: %s and stdErr: %s", redactText(stdOutString),
+				redactText(stdErrString))
 		}
 	}
-	if stdOutString != "" {
-		glog.V(LogVerbose).Infof("stdout from %s: %s", cmd.Args, stdOutString)
-	}
-	if stdErrString != "" {
-		glog.V(LogVerbose).Infof("stdErr from %s: %s", cmd.Args, stdErrString)
-	}
+	observeBeegfsCtl(args, err, start)
+	log.V(LogVerbose).Info("Command finished", "duration", time.Since(start), "stdout", redactText(stdOutString),
+		"stderr", redactText(stdErrString))
 
 	return stdOutString, err
 }
@@ -152,7 +214,7 @@ type ctlNotExistError struct {
 }
 
 func newCtlNotExistError(stdOutString, stdErrString string) ctlNotExistError {
-	return ctlNotExistError{stdOutString: stdOutString, stdErrString: stdErrString}
+	return ctlNotExistError{stdOutString: redactText(stdOutString), stdErrString: redactText(stdErrString)}
 }
 func (err ctlNotExistError) Error() string {
 	return fmt.Sprintf("beegfs-ctl failed with stdOut: %v and stdErr: %v", err.stdOutString, err.stdErrString)
@@ -165,7 +227,7 @@ type ctlExistError struct {
 }
 
 func newCtlExistError(stdOutString, stdErrString string) ctlExistError {
-	return ctlExistError{stdOutString: stdOutString, stdErrString: stdErrString}
+	return ctlExistError{stdOutString: redactText(stdOutString), stdErrString: redactText(stdErrString)}
 }
 func (err ctlExistError) Error() string {
 	return fmt.Sprintf("beegfs-ctl failed with stdOut: %v and stdErr: %v", err.stdOutString, err.stdErrString)
@@ -174,14 +236,19 @@ func (err ctlExistError) Error() string {
 // fakeBeeGFSCtlExecutor is a mock implementation of beegfsCtlExecutorInterface useful for testing.
 type fakeBeegfsCtlExecutor struct{}
 
-func (*fakeBeegfsCtlExecutor) createDirectoryForVolume(vol beegfsVolume) error {
+func (*fakeBeegfsCtlExecutor) createDirectoryForVolume(ctx context.Context, vol beegfsVolume) error {
 	return nil
 }
 
-func (*fakeBeegfsCtlExecutor) statDirectoryForVolume(vol beegfsVolume) (string, error) {
+func (*fakeBeegfsCtlExecutor) statDirectoryForVolume(ctx context.Context, vol beegfsVolume) (string, error) {
 	return "", nil
 }
 
-func (*fakeBeegfsCtlExecutor) setPatternForVolume(vol beegfsVolume, config stripePatternConfig) error {
+func (*fakeBeegfsCtlExecutor) setPatternForVolume(ctx context.Context, vol beegfsVolume,
+	config stripePatternConfig) error {
+	return nil
+}
+
+func (*fakeBeegfsCtlExecutor) checkAvailable() error {
 	return nil
 }
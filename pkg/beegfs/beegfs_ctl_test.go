@@ -6,8 +6,12 @@ Licensed under the Apache License, Version 2.0.
 package beegfs
 
 import (
+	"os"
+	"path"
 	"reflect"
 	"testing"
+
+	"github.com/spf13/afero"
 )
 
 func TestConstructSetPatternForVolume(t *testing.T) {
@@ -48,3 +52,49 @@ func TestConstructSetPatternForVolume(t *testing.T) {
 		})
 	}
 }
+
+func TestFindHostBinary(t *testing.T) {
+	tests := map[string]struct {
+		files map[string]os.FileMode // file paths relative to the host root and their permissions
+		dirs  []string
+		want  string
+	}{
+		"installed": {
+			files: map[string]os.FileMode{"/usr/bin/beegfs-ctl": 0755},
+			want:  "/usr/bin/beegfs-ctl",
+		},
+		"installed twice": {
+			files: map[string]os.FileMode{"/usr/bin/beegfs-ctl": 0755, "/usr/local/sbin/beegfs-ctl": 0700},
+			want:  "/usr/local/sbin/beegfs-ctl",
+		},
+		"not executable": {
+			files: map[string]os.FileMode{"/usr/bin/beegfs-ctl": 0644},
+		},
+		"directory": {
+			dirs: []string{"/usr/bin/beegfs-ctl"},
+		},
+		"not in search path": {
+			files: map[string]os.FileMode{"/opt/beegfs/sbin/beegfs-ctl": 0755},
+		},
+		"not installed": {},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			fs = afero.NewMemMapFs()
+			fsutil = afero.Afero{Fs: fs}
+			for filePath, perm := range tc.files {
+				if err := fsutil.WriteFile(path.Join("/host", filePath), []byte{}, perm); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, dirPath := range tc.dirs {
+				if err := fs.MkdirAll(path.Join("/host", dirPath), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := findHostBinary("/host", "beegfs-ctl"); got != tc.want {
+				t.Fatalf("expected: %q, got: %q", tc.want, got)
+			}
+		})
+	}
+}
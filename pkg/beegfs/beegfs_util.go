@@ -6,6 +6,7 @@ Licensed under the Apache License, Version 2.0.
 package beegfs
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"fmt"
 	"io/ioutil"
@@ -17,9 +18,10 @@ import (
 	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/context"
 	"gopkg.in/ini.v1"
 	"k8s.io/utils/mount"
 )
@@ -29,8 +31,43 @@ import (
 var fs = afero.NewOsFs()
 var fsutil = afero.Afero{Fs: fs}
 
-// newBeegfsUrl converts the sysMgmtdHost and path into a URL with the format beegfs://host/path.
+// These mount flags apply to a BeeGFS file system as a whole and are used when the file system is mounted during
+// NodeStageVolume.
+var beegfsMountFlags = map[string]bool{
+	"noatime":     true,
+	"nodiratime":  true,
+	"relatime":    true,
+	"strictatime": true,
+	"sync":        true,
+	"dirsync":     true,
+	"grpid":       true, // BeeGFS specific
+}
+
+// These BeeGFS specific mount flags require an unsigned integer value (e.g. logLevel=3) and are used when the file
+// system is mounted during NodeStageVolume. Other BeeGFS mount options (e.g. cfgFile or sysMgmtdHost) are managed by
+// the driver and may not be specified.
+var beegfsMountFlagsWithValue = map[string]bool{
+	"logLevel":              true,
+	"sysMountSanityCheckMS": true,
+}
+
+// These mount flags apply to an individual bind mount and are used when a volume is bind mounted during
+// NodePublishVolume.
+var bindMountFlags = map[string]bool{
+	"ro":     true,
+	"rw":     true,
+	"nosuid": true,
+	"nodev":  true,
+	"noexec": true,
+}
+
+// newBeegfsUrl converts the file system name or sysMgmtdHost and path into a URL with the format beegfs://host/path. A
+// sysMgmtdHost may include a port (e.g. beegfs://127.0.0.1:9008/path). An IPv6 address is always enclosed in brackets
+// (e.g. beegfs://[fe80::1]/path) so that its last segment is not mistaken for a port.
 func newBeegfsUrl(host string, path string) string {
+	if net.ParseIP(host) != nil && strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
 	structURL := url.URL{
 		Scheme: "beegfs",
 		Host:   host,
@@ -39,8 +76,9 @@ func newBeegfsUrl(host string, path string) string {
 	return structURL.String()
 }
 
-// parseBeegfsUrl parses a URL with the format beegfs://host/path and returns the sysMgmtdHost and path.
-func parseBeegfsUrl(rawUrl string) (sysMgmtdHost string, path string, err error) {
+// parseBeegfsUrl parses a URL with the format beegfs://host/path and returns the host and path. The host is either the
+// name of a file system or a sysMgmtdHost. Use pluginConfig.resolveFileSystem to determine which.
+func parseBeegfsUrl(rawUrl string) (fileSystem string, path string, err error) {
 	var structUrl *url.URL
 	if structUrl, err = url.Parse(rawUrl); err != nil {
 		return "", "", errors.WithStack(err)
@@ -51,15 +89,81 @@ func parseBeegfsUrl(rawUrl string) (sysMgmtdHost string, path string, err error)
 	return structUrl.Host, structUrl.Path, nil
 }
 
-// writeClientFiles writes a beegfs-client.conf file and optionally a connInterfacesFile, a connNetFilterFile, and a
-// connTcpOnlyFilterFile to a beegfsVolume's mountDirPath. The beegfs-client.conf file is generated by reading in
-// an existing beegfs-client.conf file at confTemplatePath and overriding its values with those specified in the
-// beegfsVolume's config. writeClientFiles assumes an empty directory has already been created at mountDirPath.
-func writeClientFiles(vol beegfsVolume, confTemplatePath string) (err error) {
-	glog.V(LogDebug).Infof("Writing client files for %s to %s", vol.volumeID, vol.mountDirPath)
+// clientFiles contains the rendered contents of the files writeClientFiles writes to a beegfsVolume's mountDirPath.
+// Optional files are nil if the beegfsVolume's config does not require them.
+type clientFiles struct {
+	clientConf            []byte // beegfs-client.conf
+	connInterfacesFile    []byte
+	connNetFilterFile     []byte
+	connTcpOnlyFilterFile []byte
+}
+
+// writeClientFiles writes a beegfs-client.conf file and optionally a connInterfacesFile, a connNetFilterFile, a
+// connTcpOnlyFilterFile, and a connAuthFile to a beegfsVolume's mountDirPath. The files are rendered by
+// renderClientFiles with the connClientPortUDP udpPorts selects for the mountDirPath. The connAuthFile (see
+// loadConnAuth) is only readable by its owner. writeClientFiles assumes a directory has already been created at
+// mountDirPath.
+func writeClientFiles(ctx context.Context, vol beegfsVolume, confTemplatePath string,
+	udpPorts *udpPortAllocator) (err error) {
+	_, span := startSpan(ctx, "writeClientFiles", attribute.String("beegfs.volume_id", vol.volumeID),
+		attribute.String("beegfs.mount_dir_path", vol.mountDirPath))
+	defer func() { endSpan(span, err) }()
+	newVolumeLogger(ctx, vol).V(LogDebug).Info("Writing client files", "path", vol.mountDirPath)
+
+	// The BeeGFS client must bind to and listen on a UDP port. Each BeeGFS mount requires a different port. Though
+	// the client is free to define and use its own port, BeeGFS does not support binding to port 0 to obtain an OS
+	// assigned ephemeral port.
+	port, err := udpPorts.allocate(vol.mountDirPath, vol.config.ConnClientPortUDPRange)
+	if err != nil {
+		udpPortAllocationFailuresTotal.Inc()
+		return errors.WithMessage(err, "error selecting connClientPortUDP")
+	}
+
+	files, err := renderClientFiles(vol, confTemplatePath, strconv.Itoa(port))
+	if err != nil {
+		return err
+	}
+	for _, file := range []struct {
+		name     string
+		contents []byte
+	}{
+		{name: "connInterfaces", contents: files.connInterfacesFile},
+		{name: "connNetFilter", contents: files.connNetFilterFile},
+		{name: "connTcpOnlyFilter", contents: files.connTcpOnlyFilterFile},
+	} {
+		if file.contents == nil {
+			continue
+		}
+		if err = fsutil.WriteFile(path.Join(vol.mountDirPath, file.name+"File"), file.contents, 0644); err != nil {
+			return errors.Wrapf(err, "error writing %s file", file.name)
+		}
+	}
+	connAuth, err := loadConnAuth(vol)
+	if err != nil {
+		return err
+	}
+	if connAuth != nil {
+		if err = fsutil.WriteFile(path.Join(vol.mountDirPath, "connAuthFile"), connAuth, 0400); err != nil {
+			return errors.Wrap(err, "error writing connAuth file")
+		}
+	}
+	if err = fsutil.WriteFile(vol.clientConfPath, files.clientConf, 0644); err != nil {
+		return errors.Wrap(err, "error writing beegfs-client.conf file")
+	}
+
+	return nil
+}
+
+// renderClientFiles renders the files writeClientFiles writes for a beegfsVolume without writing them. The
+// beegfs-client.conf file is generated by reading in an existing beegfs-client.conf template (see
+// loadClientConfTemplate) and overriding its values with connClientPortUDP and those specified in the beegfsVolume's
+// config. renderClientFiles returns an error if the beegfsVolume's config refers to a key that does not exist in the
+// template.
+func renderClientFiles(vol beegfsVolume, confTemplatePath, connClientPortUDP string) (files clientFiles, err error) {
 	connInterfacesFilePath := path.Join(vol.mountDirPath, "connInterfacesFile")
 	connNetFilterFilePath := path.Join(vol.mountDirPath, "connNetFilterFile")
 	connTcpOnlyFilterFilePath := path.Join(vol.mountDirPath, "connTcpOnlyFilterFile")
+	connAuthFilePath := path.Join(vol.mountDirPath, "connAuthFile")
 
 	// setConfigValueIfKeyExists is a helper function used to get around the fact that the go-ini library will allows
 	// setting the value of an arbitrary key, even if the key did not exist in the original .ini file.
@@ -73,74 +177,125 @@ func writeClientFiles(vol beegfsVolume, confTemplatePath string) (err error) {
 		return nil
 	}
 
-	// The BeeGFS client must bind to and listen on a UDP port. Each BeeGFS mount requires a different port. Though
-	// the client is free to define and use its own port, BeeGFS does not support binding to port 0 to obtain an OS
-	// assigned ephemeral port.
-	var connClientPortUDP string
-	port, err := getEphemeralPortUDP()
-	if err != nil {
-		return errors.WithMessage(err, "error selecting connClientPortUDP")
-	}
-	connClientPortUDP = strconv.Itoa(port)
-
 	var clientConfBytes []byte
 	var clientConfINI *ini.File
-	if clientConfBytes, err = fsutil.ReadFile(confTemplatePath); err != nil {
-		return errors.Wrapf(err, "error loading beegfs-client.conf file at %s", confTemplatePath)
+	if clientConfBytes, err = loadClientConfTemplate(vol, confTemplatePath); err != nil {
+		return files, err
 	}
 	if clientConfINI, err = ini.Load(clientConfBytes); err != nil {
-		return errors.Wrap(err, "error parsing template beegfs-client.conf file")
+		return files, errors.Wrap(err, "error parsing template beegfs-client.conf file")
 	}
-	if err = setConfigValueIfKeyExists(clientConfINI, "sysMgmtdHost", vol.sysMgmtdHost); err != nil {
-		return err
+	// A port in the sysMgmtdHost overrides any connMgmtdPortTCP or connMgmtdPortUDP in the beegfsVolume's config.
+	sysMgmtdHost, connMgmtdPort, err := splitSysMgmtdHost(vol.sysMgmtdHost)
+	if err != nil {
+		return files, err
+	}
+	if err = setConfigValueIfKeyExists(clientConfINI, "sysMgmtdHost", sysMgmtdHost); err != nil {
+		return files, err
 	}
 	if err = setConfigValueIfKeyExists(clientConfINI, "connClientPortUDP", connClientPortUDP); err != nil {
-		return err
+		return files, err
 	}
 	for k, v := range vol.config.BeegfsClientConf {
 		if err := setConfigValueIfKeyExists(clientConfINI, k, v); err != nil {
-			return err
+			return files, err
+		}
+	}
+	if connMgmtdPort != "" {
+		for _, key := range []string{"connMgmtdPortTCP", "connMgmtdPortUDP"} {
+			if err := setConfigValueIfKeyExists(clientConfINI, key, connMgmtdPort); err != nil {
+				return files, err
+			}
 		}
 	}
 
 	if len(vol.config.ConnInterfaces) != 0 {
-		connInterfacesFileContents := strings.Join(vol.config.ConnInterfaces, "\n") + "\n"
+		files.connInterfacesFile = []byte(strings.Join(vol.config.ConnInterfaces, "\n") + "\n")
 		if err := setConfigValueIfKeyExists(clientConfINI, "connInterfacesFile", connInterfacesFilePath); err != nil {
-			return err
-		}
-		if err = fsutil.WriteFile(connInterfacesFilePath, []byte(connInterfacesFileContents), 0644); err != nil {
-			return errors.Wrap(err, "error writing connInterfaces file")
+			return files, err
 		}
 	}
 
 	if len(vol.config.ConnNetFilter) != 0 {
-		connNetFilterFileContents := strings.Join(vol.config.ConnNetFilter, "\n") + "\n"
+		files.connNetFilterFile = []byte(strings.Join(vol.config.ConnNetFilter, "\n") + "\n")
 		if err := setConfigValueIfKeyExists(clientConfINI, "connNetFilterFile", connNetFilterFilePath); err != nil {
-			return err
-		}
-		if err = fsutil.WriteFile(connNetFilterFilePath, []byte(connNetFilterFileContents), 0644); err != nil {
-			return errors.Wrap(err, "error writing connNetFilter file")
+			return files, err
 		}
 	}
 
 	if len(vol.config.ConnTcpOnlyFilter) != 0 {
-		connTcpOnlyFilterFileContents := strings.Join(vol.config.ConnTcpOnlyFilter, "\n") + "\n"
+		files.connTcpOnlyFilterFile = []byte(strings.Join(vol.config.ConnTcpOnlyFilter, "\n") + "\n")
 		if err := setConfigValueIfKeyExists(clientConfINI, "connTcpOnlyFilterFile", connTcpOnlyFilterFilePath); err != nil {
-			return err
+			return files, err
 		}
-		if err = fsutil.WriteFile(connTcpOnlyFilterFilePath, []byte(connTcpOnlyFilterFileContents), 0644); err != nil {
-			return errors.Wrap(err, "error writing connTcpOnlyFilter file")
+	}
+
+	// The connAuthFile contents are never read here, so they cannot end up in the output (e.g. of config check).
+	if len(vol.connAuth) != 0 || vol.config.ConnAuthFile != "" {
+		if err := setConfigValueIfKeyExists(clientConfINI, "connAuthFile", connAuthFilePath); err != nil {
+			return files, err
 		}
 	}
 
-	var clientConfFileHandle afero.File
-	if clientConfFileHandle, err = fs.Create(vol.clientConfPath); err != nil {
-		return errors.Wrap(err, "error creating beegfs-client.conf file")
+	var clientConfBuffer bytes.Buffer
+	if _, err = clientConfINI.WriteTo(&clientConfBuffer); err != nil {
+		return files, errors.Wrap(err, "error rendering beegfs-client.conf file")
 	}
-	if _, err = clientConfINI.WriteTo(clientConfFileHandle); err != nil {
-		return errors.Wrap(err, "error writing beegfs-client.conf file")
+	files.clientConf = clientConfBuffer.Bytes()
+
+	return files, nil
+}
+
+// loadClientConfTemplate returns the contents of the beegfs-client.conf template for a beegfsVolume. The template is
+// the inline clientConfTemplate or the file at clientConfTemplatePath from the beegfsVolume's config if either is set.
+// Otherwise, it is the driver's template at defaultConfTemplatePath.
+func loadClientConfTemplate(vol beegfsVolume, defaultConfTemplatePath string) ([]byte, error) {
+	if vol.config.ClientConfTemplate != "" {
+		return []byte(vol.config.ClientConfTemplate), nil
 	}
+	confTemplatePath := defaultConfTemplatePath
+	if vol.config.ClientConfTemplatePath != "" {
+		confTemplatePath = vol.config.ClientConfTemplatePath
+	}
+	clientConfBytes, err := fsutil.ReadFile(confTemplatePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading beegfs-client.conf file at %s", confTemplatePath)
+	}
+	return clientConfBytes, nil
+}
+
+// loadConnAuth returns the contents of the connAuthFile for a beegfsVolume. The contents are the connAuth passed in
+// CSI secrets if there is one. Otherwise, they are read from the file at the beegfsVolume's config.ConnAuthFile.
+// loadConnAuth returns nil if the beegfsVolume does not use connection authentication. The contents are a secret and
+// must never be logged.
+func loadConnAuth(vol beegfsVolume) ([]byte, error) {
+	if len(vol.connAuth) != 0 {
+		return vol.connAuth, nil
+	}
+	if vol.config.ConnAuthFile == "" {
+		return nil, nil
+	}
+	connAuth, err := fsutil.ReadFile(vol.config.ConnAuthFile)
+	if err != nil {
+		// The path of the connAuthFile is sensitive (see redacted), so do not include it in the error.
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		return nil, errors.Wrap(err, "error reading connAuthFile")
+	}
+	if len(connAuth) == 0 {
+		return nil, errors.New("connAuthFile is empty")
+	}
+	return connAuth, nil
+}
 
+// connAuthFromSecrets returns the connAuthFile contents passed in the secrets of a CSI request or nil if there are
+// none.
+func connAuthFromSecrets(secrets map[string]string) []byte {
+	if connAuth := secrets[connAuthSecretKey]; connAuth != "" {
+		return []byte(connAuth)
+	}
 	return nil
 }
 
@@ -160,9 +315,13 @@ func squashConfigForSysMgmtdHost(sysMgmtdHost string, config pluginConfig) (retu
 }
 
 // mountIfNecessary mounts a BeeGFS file system to vol.mountPath assuming configuration files have been written to
-// vol.mountDirPath by writeClientFiles.
-func mountIfNecessary(vol beegfsVolume, mounter mount.Interface) (err error) {
-	mountOpts := []string{"rw", "relatime", "cfgFile=" + vol.clientConfPath}
+// vol.mountDirPath by writeClientFiles. mountFlags are BeeGFS level mount flags (see splitMountFlags) that are applied
+// in addition to the defaults.
+func mountIfNecessary(ctx context.Context, vol beegfsVolume, mountFlags []string, mounter mount.Interface) (err error) {
+	_, span := startSpan(ctx, "mount", attribute.String("beegfs.volume_id", vol.volumeID),
+		attribute.String("beegfs.mount_path", vol.mountPath))
+	defer func() { endSpan(span, err) }()
+	mountOpts := beegfsMountOpts(vol.clientConfPath, mountFlags)
 
 	// Check to make sure file system is not already mounted.
 	notMnt, err := mounter.IsLikelyNotMountPoint(vol.mountPath)
@@ -180,60 +339,70 @@ func mountIfNecessary(vol beegfsVolume, mounter mount.Interface) (err error) {
 
 	if !notMnt {
 		// The filesystem is already mounted. There is nothing to do.
-		glog.V(LogDebug).Infof("%s is already mounted to %s", vol.volumeID, vol.mountPath)
+		newVolumeLogger(ctx, vol).V(LogDebug).Info("Volume is already mounted", "path", vol.mountPath)
 		return nil
 	}
 
-	glog.V(LogDebug).Infof("Mounting %s to %s", vol.volumeID, vol.mountPath)
+	newVolumeLogger(ctx, vol).V(LogDebug).Info("Mounting volume", "path", vol.mountPath, "options", mountOpts)
 	if err = mounter.Mount("beegfs_nodev", vol.mountPath, "beegfs", mountOpts); err != nil {
 		return errors.WithStack(err)
 	}
 	return nil
 }
 
+// beegfsMountOpts returns the complete set of options used to mount a BeeGFS file system with the configuration file at
+// clientConfPath. mountFlags are BeeGFS level mount flags (see splitMountFlags) that are applied in addition to the
+// defaults.
+func beegfsMountOpts(clientConfPath string, mountFlags []string) []string {
+	mountOpts := []string{"rw"}
+	if !containsAtimeFlag(mountFlags) {
+		mountOpts = append(mountOpts, "relatime")
+	}
+	mountOpts = append(mountOpts, mountFlags...)
+	return append(mountOpts, "cfgFile="+clientConfPath)
+}
+
 // unmountAndCleanUpIfNecessary cleans up a mounted BeeGFS filesystem ONLY if it is not bind mounted somewhere
 // else. This is necessary to avoid trying to unmount a BeeGFS filesystem that is still in use by some container.
 // "Cleans up" in this context means unmounts the BeeGFS filesystem, deletes the mount point (mountPath), and deletes
 // all files under mountDirPath. unmountAndCleanUpIfNecessary also deletes mountDirPath if rmDir is set to true.
 // unmountAndCleanUpIfNecessary quietly continues WITHOUT error if the BeeGFS filesystem is not mounted.
-func unmountAndCleanUpIfNecessary(vol beegfsVolume, rmDir bool, mounter mount.Interface) (err error) {
+func unmountAndCleanUpIfNecessary(ctx context.Context, vol beegfsVolume, rmDir bool,
+	mounter mount.Interface) (err error) {
+	_, span := startSpan(ctx, "unmount", attribute.String("beegfs.volume_id", vol.volumeID),
+		attribute.String("beegfs.mount_path", vol.mountPath))
+	defer func() { endSpan(span, err) }()
 	// Decide whether or not to unmount BeeGFS filesystem by checking whether it is bind mounted somewhere else. We
 	// cannot use beegfsMounter.GetRefs() because we are bind mounting subdirectories (e.g. .../volume1/mount is the
 	// initial mount point but .../volume1/mount/volume1 is the directory we bind mount). beegfsMounter.GetRefs() is
 	// incapable of discovering this.
-	allMounts, err := mounter.List()
+	infos, err := readMountInfo()
 	if err != nil {
-		return errors.Wrap(err, "error listing mounted filesystems")
-	}
-	for _, entry := range allMounts {
-		// Our container mounts the host's root filesystem at /host (like /:/host), so a file system might appear to be
-		// mounted at both /path/to/file/system and /host/path/to/file/system. These duplicates are NOT bind mounts, so
-		// we use !strings.Contains() instead of entry.Path != mountPath below.
-		if entry.Device == "beegfs_nodev" && !strings.Contains(entry.Path, vol.mountPath) {
-			for _, opt := range entry.Opts {
-				if strings.Contains(opt, vol.clientConfPath) {
-					// This is a bind mount of the BeeGFS filesystem mounted at mountPath
-					return errors.Errorf("refused to unmount staged file system at %s while bind mounted at %s",
-						vol.mountPath, entry.Path)
-				}
-			}
-		}
+		return errors.WithMessage(err, "error listing mounted filesystems")
+	}
+	if bindMounts := findBindMounts(infos, vol.mountPath, vol.clientConfPath); len(bindMounts) > 0 {
+		return errors.Errorf("refused to unmount staged file system at %s while bind mounted at %s",
+			vol.mountPath, strings.Join(bindMounts, ", "))
 	}
 
-	glog.V(LogDebug).Infof("Unmounting %s from %s", vol.volumeID, vol.mountPath)
+	newVolumeLogger(ctx, vol).V(LogDebug).Info("Unmounting volume", "path", vol.mountPath)
 	if err = mount.CleanupMountPoint(vol.mountPath, mounter, false); err != nil {
 		return errors.WithStack(err)
 	}
-	if err = cleanUpIfNecessary(vol, rmDir); err != nil {
+	if err = cleanUpIfNecessary(ctx, vol, rmDir); err != nil {
 		return errors.WithMessagef(err, "failed to clean up %s for %s", vol.mountDirPath, vol.volumeID)
 	}
 	return nil
 }
 
 // cleanUpIfNecessary deletes all files associated with a beegfsVolume (in vol.mountDirPath) that is not mounted. It
-// also deletes vol.mountDirPath if rmDir is set to true.
-func cleanUpIfNecessary(vol beegfsVolume, rmDir bool) (err error) {
-	glog.V(LogDebug).Infof("Cleaning up %s for %s", vol.mountDirPath, vol.volumeID)
+// scrubs the connAuthFile (see scrubFile) before deleting anything else. It also deletes vol.mountDirPath if rmDir is
+// set to true.
+func cleanUpIfNecessary(ctx context.Context, vol beegfsVolume, rmDir bool) (err error) {
+	newVolumeLogger(ctx, vol).V(LogDebug).Info("Cleaning up volume files", "path", vol.mountDirPath)
+	if err = scrubFile(path.Join(vol.mountDirPath, "connAuthFile")); err != nil {
+		return err
+	}
 	if rmDir == false {
 		dir, err := ioutil.ReadDir(vol.mountDirPath)
 		if err != nil {
@@ -252,39 +421,42 @@ func cleanUpIfNecessary(vol beegfsVolume, rmDir bool) (err error) {
 	return nil
 }
 
-// getEphemeralPortUDP either returns an error or the system-assigned ephemeral port of a temporary UDP/IPv4 socket bound to INADDR_ANY.
-// Note: This only exists because BeeGFS does not support setting connClientPortUDP to zero.
-// Warning: Other processes on the host may bind the port returned before BeeGFS binds it.  Calling this method in a retry loop may mitigate that issue.  Ideally, BeeGFS itself should be patched to support binding to port zero.
-func getEphemeralPortUDP() (port int, err error) {
-	conn, err := net.ListenPacket("udp4", "")
+// scrubFile overwrites the file at filePath with zeros and deletes it so that a secret it contains does not remain on
+// disk. scrubFile quietly continues WITHOUT error if the file does not exist.
+func scrubFile(filePath string) (err error) {
+	info, err := fs.Stat(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	// The file may only be readable by its owner (see writeClientFiles).
+	if err = fs.Chmod(filePath, 0600); err != nil {
+		return errors.WithStack(err)
+	}
+	file, err := fs.OpenFile(filePath, os.O_WRONLY, 0)
 	if err != nil {
-		err = errors.WithStack(err)
-		return 0, err
-	}
-	defer func() {
-		if closeErr := conn.Close(); closeErr != nil {
-			closeErr = errors.WithStack(closeErr)
-			if err != nil {
-				err = errors.WithMessage(err, closeErr.Error())
-			} else {
-				err = closeErr
-			}
-		}
-	}()
-	lAddr := conn.LocalAddr()
-	lUDPAddr, err := net.ResolveUDPAddr(lAddr.Network(), lAddr.String())
+		return errors.WithStack(err)
+	}
+	if _, err = file.Write(make([]byte, info.Size())); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		err = errors.WithStack(err)
-		return 0, err
+		return errors.Wrapf(err, "error scrubbing %s", filePath)
 	}
-	return lUDPAddr.Port, nil
+	return errors.WithStack(fs.Remove(filePath))
 }
 
 // sanitizeVolumeID takes a volumeID like beegfs://127.0.0.1/scratch/vol1 and returns a string like
 // 127.0.0.1_scratch_vol1. It is primarily used to generate sane directory names for the controller service, but may
 // find other uses. sanitizeVolumeID replaces any _ in the provided volumeID with __ in the output to reduce ambiguity.
-// sanitizeVolumeID returns a sha1 hash of the volumeID if the sanitized volumeID would be over 255 characters (the
-// length limit for a file name in many file systems).
+// A port or IPv6 brackets in the volumeID are preserved (e.g. beegfs://[fe80::1]:9008/vol1 becomes
+// [fe80::1]:9008_vol1), so the host portion of the output always ends at the first single _. sanitizeVolumeID returns a
+// sha1 hash of the volumeID if the sanitized volumeID would be over 255 characters (the length limit for a file name in
+// many file systems).
 func sanitizeVolumeID(volumeID string) string {
 	sanitizedVolumeID := strings.Replace(volumeID, "beegfs://", "", 1)
 	sanitizedVolumeID = strings.Replace(sanitizedVolumeID, "_", "__", -1) // preserve existing _ as __
@@ -308,6 +480,85 @@ func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) (valid bool, rea
 		if c.GetMount() == nil || c.GetBlock() != nil {
 			return false, "access_type must be MountVolume"
 		}
+		if _, _, err := splitMountFlags(c.GetMount().GetMountFlags()); err != nil {
+			return false, err.Error()
+		}
 	}
 	return true, ""
 }
+
+// splitMountFlags checks each of the mount flags in a MountVolume (e.g. from a Kubernetes StorageClass mountOptions
+// field) against an allowlist and sorts them into flags that apply to the BeeGFS file system as a whole (beegfsFlags)
+// and flags that apply to an individual bind mount of a BeeGFS directory (bindFlags). beegfsFlags are applied when a
+// file system is staged and bindFlags are applied when a volume is published. splitMountFlags returns an error if it
+// encounters an unsupported flag.
+func splitMountFlags(mountFlags []string) (beegfsFlags, bindFlags []string, err error) {
+	for _, flag := range mountFlags {
+		key, value, hasValue := flag, "", false
+		if i := strings.Index(flag, "="); i >= 0 {
+			key, value, hasValue = flag[:i], flag[i+1:], true
+		}
+		switch {
+		case beegfsMountFlags[key] && !hasValue:
+			beegfsFlags = append(beegfsFlags, flag)
+		case beegfsMountFlagsWithValue[key] && hasValue:
+			if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+				return nil, nil, errors.Errorf("mount flag %s requires an unsigned integer value", key)
+			}
+			beegfsFlags = append(beegfsFlags, flag)
+		case bindMountFlags[key] && !hasValue:
+			bindFlags = append(bindFlags, flag)
+		default:
+			return nil, nil, errors.Errorf("mount flag %s is not supported", flag)
+		}
+	}
+	return beegfsFlags, bindFlags, nil
+}
+
+// bindMountOpts returns the options used to bind mount a BeeGFS directory onto a target path with bindFlags (see
+// splitMountFlags). A read-only request takes precedence over a rw (or duplicate ro) flag in bindFlags.
+func bindMountOpts(bindFlags []string, readOnly bool) []string {
+	opts := []string{"bind"}
+	for _, flag := range bindFlags {
+		if readOnly && (flag == "rw" || flag == "ro") {
+			continue
+		}
+		opts = append(opts, flag)
+	}
+	if readOnly {
+		opts = append(opts, "ro")
+	}
+	return opts
+}
+
+// beegfsFlagsFromMountOpts returns the BeeGFS level mount flags (see splitMountFlags) from the options of an existing
+// mount (e.g. as reported by mount.Interface.List()). All other options are discarded.
+func beegfsFlagsFromMountOpts(mountOpts []string) (beegfsFlags []string) {
+	for _, opt := range mountOpts {
+		if beegfsMountFlags[opt] || beegfsMountFlagsWithValue[strings.SplitN(opt, "=", 2)[0]] {
+			beegfsFlags = append(beegfsFlags, opt)
+		}
+	}
+	return beegfsFlags
+}
+
+// cfgFileFromMountOpts returns the value of the cfgFile option of a BeeGFS mount or "" if there is none.
+func cfgFileFromMountOpts(mountOpts []string) string {
+	for _, opt := range mountOpts {
+		if strings.HasPrefix(opt, "cfgFile=") {
+			return strings.TrimPrefix(opt, "cfgFile=")
+		}
+	}
+	return ""
+}
+
+// containsAtimeFlag returns true if mountFlags contains a flag that determines how access times are updated.
+func containsAtimeFlag(mountFlags []string) bool {
+	for _, flag := range mountFlags {
+		switch flag {
+		case "noatime", "relatime", "strictatime":
+			return true
+		}
+	}
+	return false
+}
@@ -6,13 +6,17 @@ Licensed under the Apache License, Version 2.0.
 package beegfs
 
 import (
+	"os"
 	"path"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/spf13/afero"
+	"golang.org/x/net/context"
+	"gopkg.in/ini.v1"
 )
 
 // This is included here as a constant for formatting reasons (literal looks better with no indentation involved).
@@ -56,6 +60,21 @@ func TestNewBeegfsUrl(t *testing.T) {
 			path: "/path/to/volume",
 			want: "beegfs://some.domain.com/path/to/volume",
 		},
+		"ip and port example": {
+			host: "127.0.0.1:9008",
+			path: "/path/to/volume",
+			want: "beegfs://127.0.0.1:9008/path/to/volume",
+		},
+		"IPv6 example": {
+			host: "fe80::1",
+			path: "/path/to/volume",
+			want: "beegfs://[fe80::1]/path/to/volume",
+		},
+		"IPv6 and port example": {
+			host: "[fe80::1]:9008",
+			path: "/path/to/volume",
+			want: "beegfs://[fe80::1]:9008/path/to/volume",
+		},
 	}
 
 	for name, tc := range tests {
@@ -86,6 +105,18 @@ func TestParseBeegfsUrl(t *testing.T) {
 			wantPath: "/path/to/volume",
 			wantErr:  false,
 		},
+		"ip and port example": {
+			rawUrl:   "beegfs://127.0.0.1:9008/path/to/volume",
+			wantHost: "127.0.0.1:9008",
+			wantPath: "/path/to/volume",
+			wantErr:  false,
+		},
+		"IPv6 and port example": {
+			rawUrl:   "beegfs://[fe80::1]:9008/path/to/volume",
+			wantHost: "[fe80::1]:9008",
+			wantPath: "/path/to/volume",
+			wantErr:  false,
+		},
 		"invalid URL example": {
 			rawUrl:   "beegfs:// some.domain.com/ path/to/volume",
 			wantHost: "",
@@ -128,6 +159,51 @@ func TestParseBeegfsUrl(t *testing.T) {
 	}
 }
 
+// TestBeegfsUrlRoundTrip verifies that a volume ID built from a valid sysMgmtdHost (or file system name) and absolute
+// path parses back into an equivalent host and the same path, that rebuilding the volume ID from the parsed values does
+// not change it, and that the volume ID sanitizes to a usable directory name.
+func TestBeegfsUrlRoundTrip(t *testing.T) {
+	tests := map[string]struct {
+		host, path string
+	}{
+		"IPv4":                  {host: "127.0.0.1", path: "/scratch/pvc-12345678"},
+		"IPv4 and port":         {host: "127.0.0.1:9008", path: "/scratch/pvc-12345678"},
+		"hostname and root":     {host: "localhost", path: "/"},
+		"FQDN and odd path":     {host: "Mgmtd.Example.com:9008", path: "/path with spaces/%41/?query#fragment"},
+		"file system name":      {host: "scratch", path: "/path_with_underscores/volume"},
+		"bare IPv6":             {host: "fe80::1", path: "/scratch/pvc-12345678"},
+		"bracketed IPv6":        {host: "[fe80::1]", path: "/scratch/pvc-12345678"},
+		"IPv6 and port":         {host: "[2001:db8::1]:9008", path: "/scratch/pvc-12345678"},
+		"IPv4-mapped IPv6":      {host: "::ffff:10.0.0.1", path: "/scratch/\x00\xff"},
+		"relative path":         {host: "127.0.0.1", path: "scratch/../pvc-12345678"},
+		"path with double dots": {host: "127.0.0.1", path: "/scratch/../../pvc-12345678"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			volDirPathBeegfsRoot := path.Join("/", tc.path)
+			volumeID := newBeegfsUrl(tc.host, volDirPathBeegfsRoot)
+
+			gotHost, gotPath, err := parseBeegfsUrl(volumeID)
+			if err != nil {
+				t.Fatalf("failed to parse volume ID %s: %v", volumeID, err)
+			}
+			if normalizeSysMgmtdHost(gotHost) != normalizeSysMgmtdHost(tc.host) {
+				t.Fatalf("expected host equivalent to %q, got: %q (volume ID %s)", tc.host, gotHost, volumeID)
+			}
+			if gotPath != volDirPathBeegfsRoot {
+				t.Fatalf("expected path %q, got: %q (volume ID %s)", volDirPathBeegfsRoot, gotPath, volumeID)
+			}
+			if rebuilt := newBeegfsUrl(gotHost, gotPath); rebuilt != volumeID {
+				t.Fatalf("expected rebuilt volume ID %s, got: %s", volumeID, rebuilt)
+			}
+			sanitized := sanitizeVolumeID(volumeID)
+			if sanitized == "" || len(sanitized) > 255 || strings.Contains(sanitized, "/") {
+				t.Fatalf("invalid sanitized volume ID %q for volume ID %s", sanitized, volumeID)
+			}
+		})
+	}
+}
+
 func TestWriteClientFiles(t *testing.T) {
 	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
 	fsutil = afero.Afero{Fs: fs}
@@ -162,8 +238,9 @@ func TestWriteClientFiles(t *testing.T) {
 		t.Fatalf("failed to set up new configuration directory: %v", err)
 	}
 
-	vol := newBeegfsVolume(mountDirPath, sysMgmtdHost, "test", testConfig)
-	if err := writeClientFiles(vol, confTemplatePath); err != nil {
+	vol := newBeegfsVolume(mountDirPath, sysMgmtdHost, "test", testConfig, nil)
+	udpPorts := newUDPPortAllocator("/csDataDir")
+	if err := writeClientFiles(context.Background(), vol, confTemplatePath, udpPorts); err != nil {
 		t.Fatalf("expected no error to occur: %v", err)
 	}
 
@@ -251,13 +328,6 @@ func TestSquashConfigForSysMgmtdHost(t *testing.T) {
 	}
 }
 
-func TestGetEphemeralPortUDP(t *testing.T) {
-	_, err := getEphemeralPortUDP()
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
 func TestSanitizeVolumeID(t *testing.T) {
 	tests := map[string]struct {
 		provided string
@@ -271,6 +341,14 @@ func TestSanitizeVolumeID(t *testing.T) {
 			provided: "beegfs://some.domain.com/path/to/volume",
 			want:     "some.domain.com_path_to_volume",
 		},
+		"ip and port example": {
+			provided: "beegfs://127.0.0.1:9008/path/to/volume",
+			want:     "127.0.0.1:9008_path_to_volume",
+		},
+		"IPv6 and port example": {
+			provided: "beegfs://[fe80::1]:9008/path/to/volume",
+			want:     "[fe80::1]:9008_path_to_volume",
+		},
 		"example with underscores": {
 			provided: "beegfs://some.domain.com/path_with_underscores/to/volume",
 			want:     "some.domain.com_path__with__underscores_to_volume",
@@ -345,6 +423,36 @@ func TestIsValidVolumeCapabilities(t *testing.T) {
 			},
 			wantValid: false,
 		},
+		"supported mount flags": {
+			caps: []*csi.VolumeCapability{
+				{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{
+							MountFlags: []string{"noatime", "nosuid", "nodev"},
+						},
+					},
+				},
+			},
+			wantValid: true,
+		},
+		"unsupported mount flag": {
+			caps: []*csi.VolumeCapability{
+				{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{
+							MountFlags: []string{"noatime", "cfgFile=/etc/beegfs/beegfs-client.conf"},
+						},
+					},
+				},
+			},
+			wantValid: false,
+		},
 	}
 
 	for name, tc := range tests {
@@ -356,3 +464,385 @@ func TestIsValidVolumeCapabilities(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitMountFlags(t *testing.T) {
+	tests := map[string]struct {
+		mountFlags      []string
+		wantBeegfsFlags []string
+		wantBindFlags   []string
+		wantErr         bool
+	}{
+		"no flags": {
+			mountFlags:      nil,
+			wantBeegfsFlags: nil,
+			wantBindFlags:   nil,
+		},
+		"beegfs and bind flags": {
+			mountFlags:      []string{"noatime", "nosuid", "logLevel=3", "nodev", "ro"},
+			wantBeegfsFlags: []string{"noatime", "logLevel=3"},
+			wantBindFlags:   []string{"nosuid", "nodev", "ro"},
+		},
+		"flag managed by driver": {
+			mountFlags: []string{"sysMgmtdHost=127.0.0.1"},
+			wantErr:    true,
+		},
+		"unknown flag": {
+			mountFlags: []string{"nosuid", "notaflag"},
+			wantErr:    true,
+		},
+		"value for flag without value": {
+			mountFlags: []string{"noatime=1"},
+			wantErr:    true,
+		},
+		"missing value": {
+			mountFlags: []string{"logLevel"},
+			wantErr:    true,
+		},
+		"non-integer value": {
+			mountFlags: []string{"sysMountSanityCheckMS=soon"},
+			wantErr:    true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			gotBeegfsFlags, gotBindFlags, err := splitMountFlags(tc.mountFlags)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error to occur for mount flags: %v", tc.mountFlags)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+			if !reflect.DeepEqual(tc.wantBeegfsFlags, gotBeegfsFlags) {
+				t.Fatalf("expected BeeGFS flags: %v, got: %v", tc.wantBeegfsFlags, gotBeegfsFlags)
+			}
+			if !reflect.DeepEqual(tc.wantBindFlags, gotBindFlags) {
+				t.Fatalf("expected bind flags: %v, got: %v", tc.wantBindFlags, gotBindFlags)
+			}
+		})
+	}
+}
+
+func TestBindMountOpts(t *testing.T) {
+	tests := map[string]struct {
+		bindFlags []string
+		readOnly  bool
+		want      []string
+	}{
+		"no flags":               {want: []string{"bind"}},
+		"flags":                  {bindFlags: []string{"nosuid", "rw"}, want: []string{"bind", "nosuid", "rw"}},
+		"read only":              {bindFlags: []string{"nosuid"}, readOnly: true, want: []string{"bind", "nosuid", "ro"}},
+		"read only overrides rw": {bindFlags: []string{"rw", "nodev"}, readOnly: true, want: []string{"bind", "nodev", "ro"}},
+		"read only and ro flag":  {bindFlags: []string{"ro"}, readOnly: true, want: []string{"bind", "ro"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := bindMountOpts(tc.bindFlags, tc.readOnly); !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected options: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNewBeegfsVolumeFromID(t *testing.T) {
+	config := pluginConfig{
+		FileSystems:       []namedFileSystem{{Name: "scratch", SysMgmtdHost: "127.0.0.1"}},
+		SysMgmtdHostRemap: map[string]string{"10.0.0.1": "scratch"},
+	}
+	tests := map[string]struct {
+		volumeID         string
+		wantSysMgmtdHost string
+		wantFSName       string
+		wantErr          bool
+	}{
+		"named file system": {
+			volumeID:         "beegfs://scratch/k8s/pvc-12345678",
+			wantSysMgmtdHost: "127.0.0.1",
+			wantFSName:       "scratch",
+		},
+		"legacy sysMgmtdHost": {
+			volumeID:         "beegfs://127.0.0.2/k8s/pvc-12345678",
+			wantSysMgmtdHost: "127.0.0.2",
+		},
+		"remapped legacy sysMgmtdHost": {
+			volumeID:         "beegfs://10.0.0.1/k8s/pvc-12345678",
+			wantSysMgmtdHost: "127.0.0.1",
+			wantFSName:       "scratch",
+		},
+		"invalid volume ID": {
+			volumeID: "https://scratch/k8s/pvc-12345678",
+			wantErr:  true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			vol, err := newBeegfsVolumeFromID("/mountDirPath", tc.volumeID, config, nil)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error to occur for volume ID: %s", tc.volumeID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+			if vol.sysMgmtdHost != tc.wantSysMgmtdHost || vol.fsName != tc.wantFSName {
+				t.Fatalf("expected sysMgmtdHost %s and fsName %s, got sysMgmtdHost %s and fsName %s",
+					tc.wantSysMgmtdHost, tc.wantFSName, vol.sysMgmtdHost, vol.fsName)
+			}
+			// The volume ID must not change, even if the file system it refers to is remapped.
+			if vol.volumeID != tc.volumeID {
+				t.Fatalf("expected volumeID %s, got: %s", tc.volumeID, vol.volumeID)
+			}
+			if vol.volDirPathBeegfsRoot != "/k8s/pvc-12345678" {
+				t.Fatalf("expected volDirPathBeegfsRoot /k8s/pvc-12345678, got: %s", vol.volDirPathBeegfsRoot)
+			}
+		})
+	}
+}
+
+func TestGetBeegfsClientConfFromParams(t *testing.T) {
+	config := pluginConfig{
+		FileSystemSpecificConfigs: []fileSystemSpecificConfig{{
+			SysMgmtdHost: "127.0.0.1",
+			Config: beegfsConfig{
+				BeegfsClientConf: map[string]string{"tuneFileCacheType": "buffered", "connMgmtdPortTCP": "9008"},
+			},
+		}},
+		AllowedVolumeBeegfsClientConf: []string{"tuneFileCacheType", "tuneRemoteFSync"},
+	}
+	tests := map[string]struct {
+		params  map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		"no overrides": {
+			params: map[string]string{sysMgmtdHostKey: "127.0.0.1", "stripePattern/numTargets": "4"},
+			want:   map[string]string{"tuneFileCacheType": "buffered", "connMgmtdPortTCP": "9008"},
+		},
+		"allowed overrides": {
+			params: map[string]string{
+				"beegfsClientConf/tuneFileCacheType": "native",
+				"beegfsClientConf/tuneRemoteFSync":   "false",
+			},
+			want: map[string]string{
+				"tuneFileCacheType": "native",
+				"tuneRemoteFSync":   "false",
+				"connMgmtdPortTCP":  "9008",
+			},
+		},
+		"not allowed": {
+			params:  map[string]string{"beegfsClientConf/connMgmtdPortTCP": "9108"},
+			wantErr: true,
+		},
+		"empty key": {
+			params:  map[string]string{"beegfsClientConf/": "native"},
+			wantErr: true,
+		},
+		"invalid value": {
+			params:  map[string]string{"beegfsClientConf/tuneFileCacheType": "bogus"},
+			wantErr: true,
+		},
+		"value with newline": {
+			params:  map[string]string{"beegfsClientConf/tuneFileCacheType": "native\nconnMgmtdPortTCP = 9108"},
+			wantErr: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			volBeegfsClientConf, err := getBeegfsClientConfFromParams(tc.params, config)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error to occur for params: %v", tc.params)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+			vol := newBeegfsVolume("/testvol", "127.0.0.1", "/scratch/vol1", config, volBeegfsClientConf)
+			if !reflect.DeepEqual(tc.want, vol.config.BeegfsClientConf) {
+				t.Fatalf("expected beegfsClientConf: %v, got: %v", tc.want, vol.config.BeegfsClientConf)
+			}
+		})
+	}
+	// Overrides apply to a single volume only.
+	if got := config.FileSystemSpecificConfigs[0].Config.BeegfsClientConf["tuneFileCacheType"]; got != "buffered" {
+		t.Fatalf("expected pluginConfig to be unmodified, got tuneFileCacheType: %s", got)
+	}
+}
+
+func TestRenderClientFilesMgmtdPort(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	const confTemplatePath = "/etc/beegfs/beegfs-client.conf"
+	template := "sysMgmtdHost =\nconnClientPortUDP =\nconnMgmtdPortTCP = 8008\nconnMgmtdPortUDP = 8008\n"
+	if err := fsutil.WriteFile(confTemplatePath, []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A port in the sysMgmtdHost wins over a port in beegfsClientConf.
+	config := pluginConfig{DefaultConfig: beegfsConfig{BeegfsClientConf: map[string]string{"connMgmtdPortTCP": "9000"}}}
+
+	tests := map[string]struct {
+		sysMgmtdHost                       string
+		wantSysMgmtdHost, wantTCP, wantUDP string
+	}{
+		"no port": {
+			sysMgmtdHost:     "127.0.0.1",
+			wantSysMgmtdHost: "127.0.0.1",
+			wantTCP:          "9000",
+			wantUDP:          "8008",
+		},
+		"port": {
+			sysMgmtdHost:     "127.0.0.1:9008",
+			wantSysMgmtdHost: "127.0.0.1",
+			wantTCP:          "9008",
+			wantUDP:          "9008",
+		},
+		"IPv6 and port": {
+			sysMgmtdHost:     "[fe80::1]:9008",
+			wantSysMgmtdHost: "fe80::1",
+			wantTCP:          "9008",
+			wantUDP:          "9008",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			vol := newBeegfsVolume("/testvol", tc.sysMgmtdHost, "/scratch/vol1", config, nil)
+			files, err := renderClientFiles(vol, confTemplatePath, "49152")
+			if err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+			clientConfINI, err := ini.Load(files.clientConf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range map[string]string{
+				"sysMgmtdHost":     tc.wantSysMgmtdHost,
+				"connMgmtdPortTCP": tc.wantTCP,
+				"connMgmtdPortUDP": tc.wantUDP,
+			} {
+				if got := clientConfINI.Section("").Key(key).String(); got != want {
+					t.Errorf("expected %s = %s, got: %s", key, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteClientFilesConnAuth(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	const (
+		confTemplatePath = "/etc/beegfs/beegfs-client.conf"
+		connAuthFilePath = "/etc/beegfs/connauth"
+	)
+	template := "sysMgmtdHost =\nconnClientPortUDP =\nconnAuthFile =\n"
+	if err := fsutil.WriteFile(confTemplatePath, []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsutil.WriteFile(connAuthFilePath, []byte("fromconfig"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		connAuthFile string // path in the beegfsConfig
+		secrets      map[string]string
+		want         string // expected connAuthFile contents (empty if no connAuthFile is expected)
+		wantErr      bool
+	}{
+		"none":   {},
+		"secret": {secrets: map[string]string{connAuthSecretKey: "fromsecret"}, want: "fromsecret"},
+		"config": {connAuthFile: connAuthFilePath, want: "fromconfig"},
+		"secret wins": {
+			connAuthFile: connAuthFilePath,
+			secrets:      map[string]string{connAuthSecretKey: "fromsecret"},
+			want:         "fromsecret",
+		},
+		"empty secret": {
+			connAuthFile: connAuthFilePath,
+			secrets:      map[string]string{connAuthSecretKey: ""},
+			want:         "fromconfig",
+		},
+		"missing config file": {connAuthFile: "/etc/beegfs/missing", wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			const mountDirPath = "/testvol"
+			if err := fs.RemoveAll(mountDirPath); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.Mkdir(mountDirPath, 0750); err != nil {
+				t.Fatal(err)
+			}
+			config := pluginConfig{DefaultConfig: beegfsConfig{ConnAuthFile: tc.connAuthFile}}
+			vol := newBeegfsVolume(mountDirPath, "127.0.0.1", "/scratch/vol1", config, nil)
+			vol.connAuth = connAuthFromSecrets(tc.secrets)
+			err := writeClientFiles(context.Background(), vol, confTemplatePath, newUDPPortAllocator("/csDataDir"))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error to occur")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+
+			clientConf, err := fsutil.ReadFile(vol.clientConfPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientConfINI, err := ini.Load(clientConf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotPath := clientConfINI.Section("").Key("connAuthFile").String()
+			authFilePath := path.Join(mountDirPath, "connAuthFile")
+			if tc.want == "" {
+				if gotPath != "" {
+					t.Errorf("expected connAuthFile to be unset, got: %s", gotPath)
+				}
+				if _, err := fs.Stat(authFilePath); !os.IsNotExist(err) {
+					t.Errorf("expected no connAuthFile to be written")
+				}
+				return
+			}
+			if gotPath != authFilePath {
+				t.Errorf("expected connAuthFile = %s, got: %s", authFilePath, gotPath)
+			}
+			info, err := fs.Stat(authFilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0400 {
+				t.Errorf("expected connAuthFile permissions 0400, got: %o", info.Mode().Perm())
+			}
+			if got, _ := fsutil.ReadFile(authFilePath); string(got) != tc.want {
+				t.Errorf("expected connAuthFile contents %q, got: %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestScrubFile(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	const filePath = "/testvol/connAuthFile"
+	if err := fsutil.WriteFile(filePath, []byte("secret"), 0400); err != nil {
+		t.Fatal(err)
+	}
+	if err := scrubFile(filePath); err != nil {
+		t.Fatalf("expected no error to occur: %v", err)
+	}
+	if _, err := fs.Stat(filePath); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be deleted", filePath)
+	}
+	// A file that does not exist is not an error.
+	if err := scrubFile(filePath); err != nil {
+		t.Fatalf("expected no error to occur: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// clientConfValueType is the type of value a beegfs-client.conf parameter accepts.
+type clientConfValueType int
+
+const (
+	clientConfBool clientConfValueType = iota
+	clientConfInt                      // a non-negative integer
+	clientConfPort                     // an integer from 0 to 65535
+	clientConfEnum                     // one of the values in clientConfEnumValues
+)
+
+// clientConfValueTypes contains the value types of known beegfs-client.conf parameters. Values of parameters that are
+// not listed here are not checked.
+var clientConfValueTypes = map[string]clientConfValueType{
+	"connCommRetrySecs":            clientConfInt,
+	"connDisableAuthentication":    clientConfBool,
+	"connFallbackExpirationSecs":   clientConfInt,
+	"connHelperdPortTCP":           clientConfPort,
+	"connMaxConcurrentAttempts":    clientConfInt,
+	"connMaxInternodeNum":          clientConfInt,
+	"connMgmtdPortTCP":             clientConfPort,
+	"connMgmtdPortUDP":             clientConfPort,
+	"connPortShift":                clientConfPort,
+	"connRDMABufNum":               clientConfInt,
+	"connRDMABufSize":              clientConfInt,
+	"connRDMATypeOfService":        clientConfInt,
+	"connUseRDMA":                  clientConfBool,
+	"logClientID":                  clientConfBool,
+	"logLevel":                     clientConfInt,
+	"logType":                      clientConfEnum,
+	"quotaEnabled":                 clientConfBool,
+	"sysACLsEnabled":               clientConfBool,
+	"sysCreateHardlinksAsSymlinks": clientConfBool,
+	"sysMountSanityCheckMS":        clientConfInt,
+	"sysSessionCheckOnClose":       clientConfBool,
+	"sysSyncOnClose":               clientConfBool,
+	"sysTargetOfflineTimeoutSecs":  clientConfInt,
+	"sysUpdateTargetStatesSecs":    clientConfInt,
+	"sysXAttrsEnabled":             clientConfBool,
+	"tuneFileCacheType":            clientConfEnum,
+	"tuneRemoteFSync":              clientConfBool,
+	"tuneUseGlobalAppendLocks":     clientConfBool,
+	"tuneUseGlobalFileLocks":       clientConfBool,
+}
+
+// clientConfEnumValues contains the accepted values of beegfs-client.conf parameters of type clientConfEnum.
+var clientConfEnumValues = map[string][]string{
+	"logType":           {"helperd", "syslog"},
+	"tuneFileCacheType": {"buffered", "native", "none", "paged"},
+}
+
+// clientConfBoolValues contains the boolean values BeeGFS accepts (case insensitive).
+var clientConfBoolValues = []string{"true", "false", "yes", "no", "1", "0"}
+
+// validateClientConfValue returns an error if value is not a valid value for the beegfs-client.conf parameter key.
+func validateClientConfValue(key, value string) error {
+	valueType, known := clientConfValueTypes[key]
+	if !known {
+		return nil
+	}
+	switch valueType {
+	case clientConfBool:
+		if !containsString(clientConfBoolValues, strings.ToLower(value)) {
+			return errors.Errorf("invalid value %s for %s: must be one of %s", value, key,
+				strings.Join(clientConfBoolValues, ", "))
+		}
+	case clientConfInt:
+		if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+			return errors.Errorf("invalid value %s for %s: must be a non-negative integer", value, key)
+		}
+	case clientConfPort:
+		if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+			return errors.Errorf("invalid value %s for %s: must be a port number", value, key)
+		}
+	case clientConfEnum:
+		if !containsString(clientConfEnumValues[key], value) {
+			return errors.Errorf("invalid value %s for %s: must be one of %s", value, key,
+				strings.Join(clientConfEnumValues[key], ", "))
+		}
+	}
+	return nil
+}
+
+// validateClientConf returns an error if the driver could not render a beegfs-client.conf file from the template at
+// confTemplatePath for some BeeGFS file system using config. It checks the default configuration and the configuration
+// of every file system in config's FileSystemSpecificConfigs, so it finds problems that would otherwise only be found
+// when a volume is staged. validateClientConf returns an error if the template cannot be read, if a configured
+// beegfsClientConf key (or a key the driver sets itself) does not exist in the template, or if a configured value is
+// invalid for a known beegfs-client.conf parameter.
+func validateClientConf(config pluginConfig, confTemplatePath string) error {
+	sysMgmtdHosts := []string{""} // the default configuration applies to any sysMgmtdHost
+	for _, fileSystemSpecificConfig := range config.FileSystemSpecificConfigs {
+		sysMgmtdHosts = append(sysMgmtdHosts, fileSystemSpecificConfig.SysMgmtdHost)
+	}
+	for _, sysMgmtdHost := range sysMgmtdHosts {
+		scope := "default configuration"
+		if sysMgmtdHost != "" {
+			scope = "configuration for sysMgmtdHost " + sysMgmtdHost
+		}
+		vol := newBeegfsVolume(checkMountDirPath, sysMgmtdHost, "/", config, nil)
+		// Sort keys so that the same error is reported every time.
+		keys := make([]string, 0, len(vol.config.BeegfsClientConf))
+		for key := range vol.config.BeegfsClientConf {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := validateClientConfValue(key, vol.config.BeegfsClientConf[key]); err != nil {
+				return errors.WithMessage(err, scope)
+			}
+		}
+		if _, err := renderClientFiles(vol, confTemplatePath, checkConnClientPortUDP); err != nil {
+			return errors.WithMessage(err, scope)
+		}
+	}
+	return nil
+}
+
+// containsString returns true if slice contains s.
+func containsString(slice []string, s string) bool {
+	for _, element := range slice {
+		if element == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestValidateClientConfValue(t *testing.T) {
+	tests := map[string]struct {
+		key, value string
+		wantErr    bool
+	}{
+		"unknown key":            {"someNewOption", "anything", false},
+		"bool":                   {"connUseRDMA", "true", false},
+		"bool upper case":        {"sysXAttrsEnabled", "False", false},
+		"bool number":            {"quotaEnabled", "1", false},
+		"invalid bool":           {"connUseRDMA", "enabled", true},
+		"int":                    {"connRDMABufNum", "70", false},
+		"negative int":           {"connRDMABufNum", "-1", true},
+		"invalid int":            {"sysMountSanityCheckMS", "11s", true},
+		"port":                   {"connMgmtdPortTCP", "9008", false},
+		"port out of range":      {"connMgmtdPortUDP", "65536", true},
+		"enum":                   {"tuneFileCacheType", "native", false},
+		"invalid enum":           {"tuneFileCacheType", "cached", true},
+		"enum is case sensitive": {"logType", "Syslog", true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateClientConfValue(tc.key, tc.value)
+			if tc.wantErr && err == nil {
+				t.Fatalf("expected an error to occur for %s = %s", tc.key, tc.value)
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateClientConf(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	const templatePath = "/etc/beegfs/beegfs-client.conf"
+	if err := fsutil.WriteFile(templatePath, []byte(TestWriteClientFilesTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		rawConfig    string
+		templatePath string
+		wantErr      string // substring of the expected error (empty if no error is expected)
+	}{
+		"valid": {
+			rawConfig: `config:
+  connInterfaces:
+    - ib0
+  beegfsClientConf:
+    connMgmtdPortTCP: 9008
+fileSystemSpecificConfigs:
+  - sysMgmtdHost: 127.0.0.1
+    config:
+      connNetFilter:
+        - 127.0.0.0/24
+`,
+		},
+		"default key not in template": {
+			rawConfig: "config:\n  beegfsClientConf:\n    connMgmtdPortTPC: 9008\n",
+			wantErr:   "default configuration: connMgmtdPortTPC not in template",
+		},
+		"file system specific key not in template": {
+			rawConfig: `fileSystemSpecificConfigs:
+  - sysMgmtdHost: 127.0.0.1
+    config:
+      beegfsClientConf:
+        connUseRDMA: "true"
+`,
+			wantErr: "configuration for sysMgmtdHost 127.0.0.1: connUseRDMA not in template",
+		},
+		"invalid value": {
+			rawConfig: "config:\n  beegfsClientConf:\n    connMgmtdPortTCP: nine\n",
+			wantErr:   "invalid value nine for connMgmtdPortTCP",
+		},
+		"port in sysMgmtdHost requires connMgmtdPortUDP": {
+			rawConfig: `fileSystemSpecificConfigs:
+  - sysMgmtdHost: 127.0.0.1:9008
+    config:
+      connInterfaces:
+        - ib0
+`,
+			wantErr: "connMgmtdPortUDP not in template",
+		},
+		"missing template": {
+			rawConfig:    "config:\n  connInterfaces:\n    - ib0\n",
+			templatePath: "/etc/beegfs/missing.conf",
+			wantErr:      "error loading beegfs-client.conf file",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			config, err := parseConfig([]byte(tc.rawConfig), "testnode", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.templatePath == "" {
+				tc.templatePath = templatePath
+			}
+			err = validateClientConf(config, tc.templatePath)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error to occur: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestNewBeegfsDriverInvalidClientConf verifies that the driver refuses to start with a configuration file that cannot
+// be applied to the beegfs-client.conf template.
+func TestNewBeegfsDriverInvalidClientConf(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	const (
+		templatePath = "/etc/beegfs/beegfs-client.conf"
+		configPath   = "/config/csi-beegfs-config.yaml"
+	)
+	if err := fsutil.WriteFile(templatePath, []byte(TestWriteClientFilesTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		rawConfig string
+		wantErr   bool
+	}{
+		"valid":   {rawConfig: "config:\n  beegfsClientConf:\n    connMgmtdPortTCP: 9008\n"},
+		"invalid": {rawConfig: "config:\n  beegfsClientConf:\n    tuneFileCacheType: native\n", wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := fsutil.WriteFile(configPath, []byte(tc.rawConfig), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := NewBeegfsDriver(configPath, "/csDataDir", CsDataDirSweepDisabled, "testDriver",
+				"unix:///tmp/csi.sock", "testnode", templatePath, "v0.1", nil)
+			if tc.wantErr && err == nil {
+				t.Fatal("expected an error to occur")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+		})
+	}
+}
+
+// TestClientConfTemplates verifies that each file system's beegfs-client.conf is rendered from (and validated against)
+// the template its configuration selects.
+func TestClientConfTemplates(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	const (
+		defaultTemplatePath = "/etc/beegfs/beegfs-client.conf"
+		v715TemplatePath    = "/etc/beegfs/7.1.5/beegfs-client.conf"
+	)
+	if err := fsutil.WriteFile(defaultTemplatePath, []byte(TestWriteClientFilesTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v715Template := "sysMgmtdHost =\nconnClientPortUDP =\ntuneFileCacheType = buffered\n"
+	if err := fsutil.WriteFile(v715TemplatePath, []byte(v715Template), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rawConfig := `config:
+  beegfsClientConf:
+    connMgmtdPortTCP: 9008
+fileSystemSpecificConfigs:
+  - sysMgmtdHost: 127.0.0.1
+    config:
+      clientConfTemplatePath: /etc/beegfs/7.1.5/beegfs-client.conf
+      beegfsClientConf:
+        tuneFileCacheType: native
+  - sysMgmtdHost: 127.0.0.2
+    config:
+      clientConfTemplate: |
+        sysMgmtdHost =
+        connClientPortUDP =
+        connMgmtdPortTCP = 8008
+        sysXAttrsEnabled = false
+      beegfsClientConf:
+        sysXAttrsEnabled: "true"
+`
+	config, err := parseConfig([]byte(rawConfig), "testnode", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		sysMgmtdHost string
+		want         []string // lines that must appear in the rendered beegfs-client.conf
+		wantErr      bool
+	}{
+		"default template": {
+			sysMgmtdHost: "127.0.0.3",
+			want:         []string{"connMgmtdPortTCP = 9008"},
+		},
+		// The default configuration's connMgmtdPortTCP does not exist in the 7.1.5 template.
+		"template path": {
+			sysMgmtdHost: "127.0.0.1",
+			wantErr:      true,
+		},
+		"inline template": {
+			sysMgmtdHost: "127.0.0.2",
+			want:         []string{"connMgmtdPortTCP = 9008", "sysXAttrsEnabled = true"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			vol := newBeegfsVolume("/testvol", tc.sysMgmtdHost, "/scratch/vol1", config, nil)
+			files, err := renderClientFiles(vol, defaultTemplatePath, "49152")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error to occur, got:\n%s", files.clientConf)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+			got := regexp.MustCompile(` +`).ReplaceAllString(string(files.clientConf), " ")
+			for _, line := range tc.want {
+				if !strings.Contains(got, line+"\n") {
+					t.Errorf("expected beegfs-client.conf to contain %q, got:\n%s", line, files.clientConf)
+				}
+			}
+		})
+	}
+
+	// Startup validation uses the same templates, so it finds the problem with the 7.1.5 file system.
+	err = validateClientConf(config, defaultTemplatePath)
+	if err == nil || !strings.Contains(err.Error(), "sysMgmtdHost 127.0.0.1: connMgmtdPortTCP not in template") {
+		t.Fatalf("expected error for sysMgmtdHost 127.0.0.1, got: %v", err)
+	}
+}
@@ -6,12 +6,16 @@ Licensed under the Apache License, Version 2.0.
 package beegfs
 
 import (
+	"fmt"
 	"net"
+	"path"
 	"regexp"
+	"strconv"
+	"strings"
 
-	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
+	"k8s.io/klog/v2"
 )
 
 // These parameters have no effect when specified in the beeGFSClientConf configuration section.
@@ -26,15 +30,35 @@ var unsupportedBeegfsConfOptions = []string{
 	"connInterfacesFile",
 	"connNetFilterFile",
 	"connTcpOnlyFilterFile",
+	"connAuthFile",
 }
 
 // beegfsConfig contains all of the custom configuration (above and beyond whatever is in the beegfs-client.conf file)
 // associated with a single BeeGFS file system EXCEPT for sysMgmtdHost, which is stored separately.
 type beegfsConfig struct {
-	ConnInterfaces    []string          `yaml:"connInterfaces"`
-	ConnNetFilter     []string          `yaml:"connNetFilter"`
-	ConnTcpOnlyFilter []string          `yaml:"connTcpOnlyFilter"`
-	BeegfsClientConf  map[string]string `yaml:"beegfsClientConf"`
+	ConnInterfaces        []string          `yaml:"connInterfaces"`
+	ConnNetFilter         []string          `yaml:"connNetFilter"`
+	ConnTcpOnlyFilter     []string          `yaml:"connTcpOnlyFilter"`
+	BeegfsClientConf      map[string]string `yaml:"beegfsClientConf"`
+	AllowEphemeralVolumes *bool             `yaml:"allowEphemeralVolumes"` // nil (unset) is the same as false
+	// ConnInterfacesSelectors select connInterfaces from the node's network interfaces by CIDR (e.g. 10.10.0.0/16) or
+	// name pattern (e.g. ib*) in order of preference (see resolveConnInterfaces). At most one of ConnInterfaces and
+	// ConnInterfacesSelectors may be set in any beegfsConfig.
+	ConnInterfacesSelectors []string `yaml:"connInterfacesSelectors"`
+	// ClientConfTemplatePath and ClientConfTemplate override the driver's beegfs-client.conf template (e.g. for a file
+	// system running a different BeeGFS version). At most one of them may be set in any beegfsConfig.
+	ClientConfTemplatePath string `yaml:"clientConfTemplatePath"` // path to a beegfs-client.conf template
+	ClientConfTemplate     string `yaml:"clientConfTemplate"`     // contents of a beegfs-client.conf template
+	// ConnAuthFile is the path to a file containing the BeeGFS connection authentication secret. The driver copies the
+	// file into a volume's mountDirPath and points connAuthFile at the copy. A secret passed in CSI secrets takes
+	// precedence. Fields tagged sensitive are redacted when configuration is logged (see redacted).
+	ConnAuthFile string `yaml:"connAuthFile" sensitive:"true"`
+	// ConnClientPortUDPRange restricts the connClientPortUDP the driver selects for each mount (e.g. "40000-40999").
+	ConnClientPortUDPRange string `yaml:"connClientPortUDPRange"`
+	// AccessibleTopology lists the topologies (sets of node topology segments, see nodeInfoConfig) from which a file
+	// system is reachable. A volume is accessible from a node if all segments of any entry match the node's segments.
+	// An empty AccessibleTopology means a file system is reachable from every node.
+	AccessibleTopology []map[string]string `yaml:"accessibleTopology"`
 }
 
 func newBeegfsConfig() *beegfsConfig {
@@ -49,21 +73,153 @@ type fileSystemSpecificConfig struct {
 	Config       beegfsConfig `yaml:"config"`
 }
 
-// nodeSpecificConfig associates a default beegfsConfig and a list of file system specific configurations with a list
-// of nodes.
+// namedFileSystem associates a name with a BeeGFS file system (sysMgmtdHost) and a beegfsConfig. StorageClasses and
+// volume IDs can refer to a named file system by name instead of by sysMgmtdHost, so the file system's sysMgmtdHost can
+// change without affecting existing volumes. parseConfig adds Config to the pluginConfig's FileSystemSpecificConfigs
+// (for SysMgmtdHost) so that it is overridden by node specific configuration like any fileSystemSpecificConfig.
+type namedFileSystem struct {
+	Name         string       `yaml:"name"`
+	SysMgmtdHost string       `yaml:"sysMgmtdHost"`
+	Config       beegfsConfig `yaml:"config"`
+}
+
+// nodeInfoConfig describes a node to the container orchestrator (see NodeGetInfo). Unlike a beegfsConfig, it does not
+// apply to a particular BeeGFS file system.
+type nodeInfoConfig struct {
+	// MaxVolumesPerNode is the maximum number of volumes the orchestrator schedules on the node. 0 means no limit.
+	MaxVolumesPerNode int64 `yaml:"maxVolumesPerNode"`
+	// TopologySegments describe what the node can reach (e.g. beegfs.csi.netapp.com/fabric: ib).
+	TopologySegments map[string]string `yaml:"topologySegments"`
+}
+
+// nodeSpecificConfig associates a default beegfsConfig, a list of file system specific configurations, and node
+// information with a set of nodes. A nodeSpecificConfig applies to a node if any of its selectors matches the node
+// (see appliesTo).
 type nodeSpecificConfig struct {
-	NodeList                  []string                   `yaml:"nodeList"`
+	NodeList                  []string                   `yaml:"nodeList"`         // exact node IDs
+	NodeNamePatterns          []string                   `yaml:"nodeNamePatterns"` // shell patterns (see path.Match)
+	NodeNameRegexes           []string                   `yaml:"nodeNameRegexes"`  // RE2 expressions matching whole IDs
+	NodeLabels                map[string]string          `yaml:"nodeLabels"`       // all must match the node's labels
 	DefaultConfig             beegfsConfig               `yaml:"config"`
 	FileSystemSpecificConfigs []fileSystemSpecificConfig `yaml:"fileSystemSpecificConfigs"`
+	NodeInfo                  nodeInfoConfig             `yaml:"nodeInfo"`
+}
+
+// validate returns an error if any of nodeConfig's node name patterns or regular expressions is malformed.
+func (nodeConfig nodeSpecificConfig) validate() error {
+	if err := nodeConfig.NodeInfo.validate(); err != nil {
+		return err
+	}
+	for _, pattern := range nodeConfig.NodeNamePatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid nodeNamePattern %s", pattern)
+		}
+	}
+	for _, expr := range nodeConfig.NodeNameRegexes {
+		if _, err := compileNodeNameRegex(expr); err != nil {
+			return errors.Wrapf(err, "invalid nodeNameRegex %s", expr)
+		}
+	}
+	return nil
+}
+
+// appliesTo returns true if nodeID is in nodeConfig's NodeList, nodeID matches any of its NodeNamePatterns or
+// NodeNameRegexes, or nodeLabels contains all of its NodeLabels (if it has any). appliesTo assumes nodeConfig has
+// been validated.
+func (nodeConfig nodeSpecificConfig) appliesTo(nodeID string, nodeLabels map[string]string) bool {
+	for _, nodeName := range nodeConfig.NodeList {
+		if nodeID == nodeName {
+			return true
+		}
+	}
+	for _, pattern := range nodeConfig.NodeNamePatterns {
+		if matched, _ := path.Match(pattern, nodeID); matched {
+			return true
+		}
+	}
+	for _, expr := range nodeConfig.NodeNameRegexes {
+		if re, err := compileNodeNameRegex(expr); err == nil && re.MatchString(nodeID) {
+			return true
+		}
+	}
+	if len(nodeConfig.NodeLabels) == 0 {
+		return false
+	}
+	for key, value := range nodeConfig.NodeLabels {
+		if nodeValue, ok := nodeLabels[key]; !ok || nodeValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// compileNodeNameRegex compiles expr so that it only matches entire node IDs.
+func compileNodeNameRegex(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// LoadNodeLabels returns the node labels nodeSpecificConfigs can select on. labels is a comma separated list of
+// key=value pairs. labelsFilePath (if not empty) is a file containing one key=value pair per line, in which a value may
+// be enclosed in double quotes (key="value") and empty lines and lines starting with # are ignored. The Kubernetes
+// downward API cannot expose node labels, so the file is typically written to the host by node provisioning tooling
+// and mounted into the node service's container. Labels in labels take precedence over labels in the file.
+func LoadNodeLabels(labels, labelsFilePath string) (map[string]string, error) {
+	nodeLabels := make(map[string]string)
+	if labelsFilePath != "" {
+		labelsBytes, err := fsutil.ReadFile(labelsFilePath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read node labels file")
+		}
+		for _, line := range strings.Split(string(labelsBytes), "\n") {
+			if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			key, value, err := parseNodeLabel(line)
+			if err != nil {
+				return nil, errors.WithMessage(err, "failed to parse node labels file")
+			}
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+			nodeLabels[key] = value
+		}
+	}
+	if labels != "" {
+		for _, label := range strings.Split(labels, ",") {
+			key, value, err := parseNodeLabel(strings.TrimSpace(label))
+			if err != nil {
+				return nil, err
+			}
+			nodeLabels[key] = value
+		}
+	}
+	return nodeLabels, nil
+}
+
+// parseNodeLabel splits a key=value pair.
+func parseNodeLabel(label string) (key, value string, err error) {
+	parts := strings.SplitN(label, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", errors.Errorf("invalid node label %s", label)
+	}
+	return parts[0], parts[1], nil
 }
 
 // pluginConfig contains a default beegfsConfig and a list of file system specific configurations. It is the
-// configuration that is maintained for the life of the running plugin. It does NOT contain node specific
-// configurations. The plugin creates its pluginConfig on startup by iterating through any  node specific
-// configurations and accounting for those that apply to the node it is running on.
+// configuration that is maintained by the running plugin (see pluginConfigStore). It does NOT contain node specific
+// configurations. The plugin creates its pluginConfig on startup (and whenever the configuration file changes) by
+// iterating through any  node specific configurations and accounting for those that apply to the node it is running on.
 type pluginConfig struct {
 	DefaultConfig             beegfsConfig               `yaml:"config"`
 	FileSystemSpecificConfigs []fileSystemSpecificConfig `yaml:"fileSystemSpecificConfigs"`
+	FileSystems               []namedFileSystem          `yaml:"fileSystems"`
+	NodeInfo                  nodeInfoConfig             `yaml:"nodeInfo"`
+	// AllowedVolumeBeegfsClientConf lists the beegfsClientConf parameters that StorageClass parameters (and ephemeral
+	// volume attributes) may override for a single volume (e.g. beegfsClientConf/tuneFileCacheType: native).
+	AllowedVolumeBeegfsClientConf []string `yaml:"allowedVolumeBeegfsClientConf"`
+	// SysMgmtdHostRemap maps a sysMgmtdHost found in existing volume IDs (or StorageClasses) to the name of a file
+	// system in FileSystems or to a different sysMgmtdHost.
+	SysMgmtdHostRemap map[string]string `yaml:"sysMgmtdHostRemap"`
 }
 
 // pluginConfigFromFile contains a pluginConfig and a list of node specific configurations. It is only used
@@ -74,43 +230,85 @@ type pluginConfigFromFile struct {
 	NodeSpecificConfigs []nodeSpecificConfig `yaml:"nodeSpecificConfigs"`
 }
 
-// parseConfigFromFile reads the file at the specified path, unmarshalls it into a pluginConfigFromFile, and constructs
-// a pluginConfig. It uses nodeID to determine if any node specific configuration applies to the node the plugin is
-// running on. If it does, the final pluginConfig contains node specific overrides.
-func parseConfigFromFile(path, nodeID string) (pluginConfig, error) {
-	var rawConfig pluginConfigFromFile
-	var newPluginConfig pluginConfig
+// normalizeSysMgmtdHosts replaces every sysMgmtdHost in the configuration with its canonical form (see
+// normalizeSysMgmtdHost) so that configuration for the same file system can be matched by simple comparison.
+func (rawConfig *pluginConfigFromFile) normalizeSysMgmtdHosts() {
+	normalizeFileSystemSpecificConfigs := func(configs []fileSystemSpecificConfig) {
+		for i := range configs {
+			configs[i].SysMgmtdHost = normalizeSysMgmtdHost(configs[i].SysMgmtdHost)
+		}
+	}
+	normalizeFileSystemSpecificConfigs(rawConfig.FileSystemSpecificConfigs)
+	for i := range rawConfig.NodeSpecificConfigs {
+		normalizeFileSystemSpecificConfigs(rawConfig.NodeSpecificConfigs[i].FileSystemSpecificConfigs)
+	}
+	for i := range rawConfig.FileSystems {
+		rawConfig.FileSystems[i].SysMgmtdHost = normalizeSysMgmtdHost(rawConfig.FileSystems[i].SysMgmtdHost)
+	}
+	if rawConfig.SysMgmtdHostRemap != nil {
+		remap := make(map[string]string, len(rawConfig.SysMgmtdHostRemap))
+		for oldHost, newHostOrName := range rawConfig.SysMgmtdHostRemap {
+			remap[normalizeSysMgmtdHost(oldHost)] = normalizeSysMgmtdHost(newHostOrName)
+		}
+		rawConfig.SysMgmtdHostRemap = remap
+	}
+}
 
-	// read and parse configuration file
-	// return immediately if an error occurs
+// parseConfigFromFile reads the file at the specified path, unmarshalls it into a pluginConfigFromFile, and constructs
+// a pluginConfig. It uses nodeID and nodeLabels to determine if any node specific configurations apply to the node the
+// plugin is running on. If they do, the final pluginConfig contains node specific overrides. When multiple node
+// specific configurations apply, they are applied in the order they appear in the file (so later configurations take
+// precedence over earlier ones).
+func parseConfigFromFile(path, nodeID string, nodeLabels map[string]string) (pluginConfig, error) {
 	rawConfigBytes, err := fsutil.ReadFile(path)
 	if err != nil {
 		return pluginConfig{}, errors.Wrap(err, "failed to read configuration file")
 	}
+	return parseConfig(rawConfigBytes, nodeID, nodeLabels)
+}
+
+// parseConfig does the work of parseConfigFromFile on the contents of a configuration file.
+func parseConfig(rawConfigBytes []byte, nodeID string, nodeLabels map[string]string) (pluginConfig, error) {
+	var rawConfig pluginConfigFromFile
+	var newPluginConfig pluginConfig
+
+	// parse configuration file
+	// return immediately if an error occurs
 	if err := yaml.UnmarshalStrict(rawConfigBytes, &rawConfig); err != nil {
 		return pluginConfig{}, errors.Wrap(err, "failed to unmarshal configuration file")
 	}
-	glog.V(LogDebug).Infof("Raw configuration parsed from %s: %+v", path, rawConfig)
+	klog.V(LogDebug).InfoS("Parsed raw configuration", "config", fmt.Sprintf("%+v", rawConfig.redacted()))
+	rawConfig.normalizeSysMgmtdHosts()
 
 	// start populating newPluginConfig using values directly from rawConfig
 	newPluginConfig = pluginConfig{
-		DefaultConfig:             rawConfig.DefaultConfig,
-		FileSystemSpecificConfigs: rawConfig.FileSystemSpecificConfigs,
+		DefaultConfig:                 rawConfig.DefaultConfig,
+		FileSystemSpecificConfigs:     rawConfig.FileSystemSpecificConfigs,
+		FileSystems:                   rawConfig.FileSystems,
+		NodeInfo:                      rawConfig.NodeInfo,
+		AllowedVolumeBeegfsClientConf: rawConfig.AllowedVolumeBeegfsClientConf,
+		SysMgmtdHostRemap:             rawConfig.SysMgmtdHostRemap,
+	}
+
+	// treat the configuration of each named file system like a fileSystemSpecificConfig for its sysMgmtdHost
+	for _, namedFS := range rawConfig.FileSystems {
+		newPluginConfig.FileSystemSpecificConfigs = overwriteFileSystemSpecificConfigs(
+			newPluginConfig.FileSystemSpecificConfigs,
+			[]fileSystemSpecificConfig{{SysMgmtdHost: namedFS.SysMgmtdHost, Config: namedFS.Config}})
 	}
 
 	// overwrite newPluginConfig with anything found in NodeSpecificConfigs pertaining to this node
-	for _, nodeConfig := range rawConfig.NodeSpecificConfigs {
-		appliesToNode := false
-		for _, nodeName := range nodeConfig.NodeList {
-			if nodeID == nodeName {
-				appliesToNode = true
-				break
-			}
+	for i, nodeConfig := range rawConfig.NodeSpecificConfigs {
+		// Validate every node specific configuration (not just those that apply) so that errors are caught no matter
+		// which node parses the file first.
+		if err := nodeConfig.validate(); err != nil {
+			return pluginConfig{}, errors.WithMessagef(err, "config validation failed for nodeSpecificConfigs[%d]", i)
 		}
-		if appliesToNode {
+		if nodeConfig.appliesTo(nodeID, nodeLabels) {
 			newPluginConfig.DefaultConfig.overwriteFrom(nodeConfig.DefaultConfig)
 			newPluginConfig.FileSystemSpecificConfigs = overwriteFileSystemSpecificConfigs(
 				newPluginConfig.FileSystemSpecificConfigs, nodeConfig.FileSystemSpecificConfigs)
+			newPluginConfig.NodeInfo.overwriteFrom(nodeConfig.NodeInfo)
 		}
 	}
 
@@ -118,25 +316,98 @@ func parseConfigFromFile(path, nodeID string) (pluginConfig, error) {
 		return pluginConfig{}, errors.WithMessage(err, "config validation failed")
 	}
 	newPluginConfig.stripConfig()
-	glog.V(LogDebug).Infof("Actual configuration to be applied: %+v", newPluginConfig)
+	klog.V(LogDebug).InfoS("Applying configuration", "config", fmt.Sprintf("%+v", newPluginConfig.redacted()))
 
 	return newPluginConfig, nil
 }
 
 func (plConfig *pluginConfig) validateConfig() error {
+	if err := plConfig.NodeInfo.validate(); err != nil {
+		return err
+	}
+
 	beegfsConfigs := []beegfsConfig{plConfig.DefaultConfig}
-	// this regex is used to determine whether a given string is a domain name
-	domainRegex := regexp.MustCompile("^(?:[_a-z0-9](?:[_a-z0-9-]{0,61}[a-z0-9]\\.)|(?:[0-9]+/[0-9]{2})\\.)+(?:[a-z](?:[a-z0-9-]{0,61}[a-z0-9])?)?$")
 	for _, config := range plConfig.FileSystemSpecificConfigs {
-		// sysMgmtdHost can be localhost, an IP address, or a domain name. if it is none of these, return an error
-		if config.SysMgmtdHost != "localhost" && net.ParseIP(config.SysMgmtdHost) == nil &&
-			!domainRegex.MatchString(config.SysMgmtdHost) {
+		if !isValidSysMgmtdHost(config.SysMgmtdHost) {
 			return errors.Errorf("invalid SysMgmtdHost %s", config.SysMgmtdHost)
 		}
 		beegfsConfigs = append(beegfsConfigs, config.Config)
 	}
 
+	names := make(map[string]bool)
+	sysMgmtdHosts := make(map[string]bool)
+	for _, namedFS := range plConfig.FileSystems {
+		if !fileSystemNameRegex.MatchString(namedFS.Name) {
+			return errors.Errorf("invalid file system name %s", namedFS.Name)
+		}
+		if names[namedFS.Name] {
+			return errors.Errorf("duplicate file system name %s", namedFS.Name)
+		}
+		if !isValidSysMgmtdHost(namedFS.SysMgmtdHost) {
+			return errors.Errorf("invalid SysMgmtdHost %s for file system %s", namedFS.SysMgmtdHost, namedFS.Name)
+		}
+		if sysMgmtdHosts[namedFS.SysMgmtdHost] {
+			return errors.Errorf("SysMgmtdHost %s is used by more than one file system", namedFS.SysMgmtdHost)
+		}
+		names[namedFS.Name] = true
+		sysMgmtdHosts[namedFS.SysMgmtdHost] = true
+	}
+	for _, key := range plConfig.AllowedVolumeBeegfsClientConf {
+		if key == "" || containsString(noEffectBeegfsConfOptions, key) ||
+			containsString(unsupportedBeegfsConfOptions, key) || containsString(sensitiveBeegfsClientConfKeys, key) {
+			return errors.Errorf("beegfsClientConf parameter %s can not be allowed in volume parameters", key)
+		}
+	}
+	for oldHost, newHostOrName := range plConfig.SysMgmtdHostRemap {
+		if oldHost == "" {
+			return errors.New("empty SysMgmtdHost in SysMgmtdHostRemap")
+		}
+		if !names[newHostOrName] && !isValidSysMgmtdHost(newHostOrName) {
+			return errors.Errorf("invalid SysMgmtdHostRemap target %s for %s", newHostOrName, oldHost)
+		}
+	}
+	// A file system name that is also a configured sysMgmtdHost would silently take over the volumes and StorageClasses
+	// that refer to that sysMgmtdHost (see resolveFileSystem).
+	configuredHosts := make([]string, 0, len(plConfig.FileSystemSpecificConfigs)+len(plConfig.FileSystems)+
+		len(plConfig.SysMgmtdHostRemap))
+	for _, config := range plConfig.FileSystemSpecificConfigs {
+		configuredHosts = append(configuredHosts, config.SysMgmtdHost)
+	}
+	for _, namedFS := range plConfig.FileSystems {
+		configuredHosts = append(configuredHosts, namedFS.SysMgmtdHost)
+	}
+	for oldHost := range plConfig.SysMgmtdHostRemap {
+		configuredHosts = append(configuredHosts, oldHost)
+	}
+	for _, sysMgmtdHost := range configuredHosts {
+		if names[sysMgmtdHost] {
+			return errors.Errorf("file system name %s is also used as a SysMgmtdHost", sysMgmtdHost)
+		}
+	}
+
 	for _, config := range beegfsConfigs {
+		if config.ClientConfTemplatePath != "" && config.ClientConfTemplate != "" {
+			return errors.New("only one of clientConfTemplatePath and clientConfTemplate can be specified")
+		}
+		if len(config.ConnInterfaces) != 0 && len(config.ConnInterfacesSelectors) != 0 {
+			return errors.New("only one of connInterfaces and connInterfacesSelectors can be specified")
+		}
+		for _, selector := range config.ConnInterfacesSelectors {
+			if err := validateConnInterfacesSelector(selector); err != nil {
+				return err
+			}
+		}
+		if _, _, err := parsePortRange(config.ConnClientPortUDPRange); err != nil {
+			return err
+		}
+		for _, segments := range config.AccessibleTopology {
+			if len(segments) == 0 {
+				return errors.New("empty accessibleTopology entry")
+			}
+			if err := validateTopologySegments(segments); err != nil {
+				return errors.WithMessage(err, "invalid accessibleTopology")
+			}
+		}
 		for _, filter := range config.ConnNetFilter {
 			if _, _, err := net.ParseCIDR(filter); err != nil && net.ParseIP(filter) == nil {
 				return errors.Errorf("invalid ConnNetFilter %s", filter)
@@ -152,26 +423,174 @@ func (plConfig *pluginConfig) validateConfig() error {
 	return nil
 }
 
-// stripConfig removes any no-effect beegfsConf options from the plugin configuration, logging a warning if any are
-// found. It also logs a warning (but does not remove) any unsupported options it finds. See deployment.md for the list
-// of no-effect options.
+// topologyNameRegex matches the name of a topology segment key (after the optional prefix) and a topology segment
+// value. The CSI spec requires both to follow the rules for Kubernetes label keys and values.
+var topologyNameRegex = regexp.MustCompile("^[a-zA-Z0-9](?:[a-zA-Z0-9_.-]{0,61}[a-zA-Z0-9])?$")
+
+// validate returns an error if nodeInfo can not be returned in a NodeGetInfoResponse.
+func (n nodeInfoConfig) validate() error {
+	if n.MaxVolumesPerNode < 0 {
+		return errors.Errorf("invalid maxVolumesPerNode %d", n.MaxVolumesPerNode)
+	}
+	return validateTopologySegments(n.TopologySegments)
+}
+
+// validateTopologySegments returns an error if any key or value in segments can not be used in a csi.Topology.
+func validateTopologySegments(segments map[string]string) error {
+	for key, value := range segments {
+		name := key
+		if i := strings.LastIndex(key, "/"); i >= 0 {
+			prefix := key[:i]
+			name = key[i+1:]
+			if !isValidHostname(prefix) || strings.ToLower(prefix) != prefix {
+				return errors.Errorf("invalid topology segment key %s", key)
+			}
+		}
+		if !topologyNameRegex.MatchString(name) {
+			return errors.Errorf("invalid topology segment key %s", key)
+		}
+		if !topologyNameRegex.MatchString(value) {
+			return errors.Errorf("invalid value %s for topology segment key %s", value, key)
+		}
+	}
+	return nil
+}
+
+// hostnameLabelRegex matches a single label of an RFC 1123 host name.
+var hostnameLabelRegex = regexp.MustCompile("^[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$")
+
+// fileSystemNameRegex matches valid file system names. A name must be usable as the host portion of a volume ID, so
+// it is restricted to an RFC 1123 DNS label.
+var fileSystemNameRegex = regexp.MustCompile("^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?$")
+
+// isValidHostname returns true if hostname is a valid RFC 1123 host name (e.g. localhost, mgmtd, or
+// Mgmtd.Example.com). Host names are case insensitive. A name whose last label is entirely numeric is rejected so that
+// an invalid IPv4 address (e.g. 10.0.0.256) is not mistaken for a host name.
+func isValidHostname(hostname string) bool {
+	if len(hostname) == 0 || len(hostname) > 253 {
+		return false
+	}
+	labels := strings.Split(hostname, ".")
+	for _, label := range labels {
+		if !hostnameLabelRegex.MatchString(label) {
+			return false
+		}
+	}
+	if _, err := strconv.ParseUint(labels[len(labels)-1], 10, 64); err == nil {
+		return false
+	}
+	return true
+}
+
+// isValidSysMgmtdHost returns true if sysMgmtdHost is an IPv4 address, an IPv6 address, or an RFC 1123 host name,
+// optionally followed by a port (see splitSysMgmtdHost). Only an IPv6 address may be enclosed in brackets.
+func isValidSysMgmtdHost(sysMgmtdHost string) bool {
+	host, _, err := splitSysMgmtdHost(sysMgmtdHost)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(sysMgmtdHost, "[") {
+		return strings.Contains(host, ":") && net.ParseIP(host) != nil
+	}
+	return net.ParseIP(host) != nil || isValidHostname(host)
+}
+
+// splitSysMgmtdHost splits a sysMgmtdHost of the form host, host:port, [host], or [host]:port into a host and a port
+// (which is empty if sysMgmtdHost does not include one). An IPv6 address must be enclosed in brackets if it is followed
+// by a port. splitSysMgmtdHost does not validate host.
+func splitSysMgmtdHost(sysMgmtdHost string) (host, port string, err error) {
+	if net.ParseIP(sysMgmtdHost) != nil {
+		return sysMgmtdHost, "", nil // an IPv4 address or an IPv6 address without brackets
+	}
+	if strings.HasPrefix(sysMgmtdHost, "[") && strings.HasSuffix(sysMgmtdHost, "]") {
+		return sysMgmtdHost[1 : len(sysMgmtdHost)-1], "", nil
+	}
+	if !strings.Contains(sysMgmtdHost, ":") {
+		return sysMgmtdHost, "", nil
+	}
+	if host, port, err = net.SplitHostPort(sysMgmtdHost); err != nil {
+		return "", "", errors.Wrapf(err, "invalid sysMgmtdHost %s", sysMgmtdHost)
+	}
+	if portNum, err := strconv.ParseUint(port, 10, 16); err != nil || portNum == 0 {
+		return "", "", errors.Errorf("invalid port %s in sysMgmtdHost %s", port, sysMgmtdHost)
+	}
+	return host, port, nil
+}
+
+// normalizeSysMgmtdHost returns the canonical form of sysMgmtdHost so that equivalent sysMgmtdHosts (e.g. FE80::1 and
+// [fe80::1] or Mgmtd.Example.com and mgmtd.example.com) compare equal. IP addresses are formatted by net.IP.String,
+// host names are lower case, and an IPv6 address is enclosed in brackets only if it is followed by a port. A
+// sysMgmtdHost that cannot be split is returned unchanged.
+func normalizeSysMgmtdHost(sysMgmtdHost string) string {
+	host, port, err := splitSysMgmtdHost(sysMgmtdHost)
+	if err != nil {
+		return sysMgmtdHost
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	} else {
+		host = strings.ToLower(host)
+	}
+	if port == "" {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// resolveFileSystem returns the sysMgmtdHost of the BeeGFS file system fileSystem refers to and, if fileSystem refers
+// to a named file system, its name. fileSystem is either the name of a file system in FileSystems or a sysMgmtdHost. A
+// sysMgmtdHost in SysMgmtdHostRemap is replaced by the name or sysMgmtdHost it maps to before it is resolved. A name
+// takes precedence over an identical sysMgmtdHost.
+func (plConfig pluginConfig) resolveFileSystem(fileSystem string) (sysMgmtdHost, fsName string) {
+	if namedFS, ok := plConfig.lookupFileSystem(fileSystem); ok {
+		return namedFS.SysMgmtdHost, namedFS.Name
+	}
+	sysMgmtdHost = normalizeSysMgmtdHost(fileSystem)
+	if newHostOrName, ok := plConfig.SysMgmtdHostRemap[sysMgmtdHost]; ok {
+		if namedFS, ok := plConfig.lookupFileSystem(newHostOrName); ok {
+			return namedFS.SysMgmtdHost, namedFS.Name
+		}
+		return newHostOrName, ""
+	}
+	return sysMgmtdHost, ""
+}
+
+// lookupFileSystem returns the file system in FileSystems named name.
+func (plConfig pluginConfig) lookupFileSystem(name string) (namedFileSystem, bool) {
+	for _, namedFS := range plConfig.FileSystems {
+		if namedFS.Name == name {
+			return namedFS, true
+		}
+	}
+	return namedFileSystem{}, false
+}
+
+// stripConfig removes any no-effect beegfsConf options from the plugin configuration, logging any that are found. It
+// also logs (but does not remove) any unsupported options it finds. See deployment.md for the list of no-effect
+// options.
 func (plConfig *pluginConfig) stripConfig() {
-	beegfsConfigs := []beegfsConfig{plConfig.DefaultConfig}
+	// Each config is logged with the key-value pair that identifies it.
+	type strippedConfig struct {
+		config    beegfsConfig
+		logValues []interface{}
+	}
+	beegfsConfigs := []strippedConfig{{config: plConfig.DefaultConfig, logValues: []interface{}{"default_config", true}}}
 	for _, config := range plConfig.FileSystemSpecificConfigs {
-		beegfsConfigs = append(beegfsConfigs, config.Config)
+		beegfsConfigs = append(beegfsConfigs, strippedConfig{config: config.Config,
+			logValues: []interface{}{"sys_mgmtd_host", config.SysMgmtdHost}})
 	}
-	for _, config := range beegfsConfigs {
+	for _, c := range beegfsConfigs {
 		for _, noEffectOption := range noEffectBeegfsConfOptions {
-			if val, present := config.BeegfsClientConf[noEffectOption]; present {
-				glog.Warningf("No-effect beegfs configuration option %s=%s found and removed from config",
-					noEffectOption, val)
-				delete(config.BeegfsClientConf, noEffectOption)
+			if val, present := c.config.BeegfsClientConf[noEffectOption]; present {
+				klog.InfoS("Removed no-effect beegfs configuration option from config", append(c.logValues,
+					"option", noEffectOption, "value", redactBeegfsClientConfValue(noEffectOption, val))...)
+				delete(c.config.BeegfsClientConf, noEffectOption)
 			}
 		}
 		for _, unsupportedOption := range unsupportedBeegfsConfOptions {
-			if val, present := config.BeegfsClientConf[unsupportedOption]; present {
-				glog.Warningf("Unsupported beegfs configuration option %s=%s found and left in config",
-					unsupportedOption, val)
+			if val, present := c.config.BeegfsClientConf[unsupportedOption]; present {
+				klog.InfoS("Unsupported beegfs configuration option found and left in config", append(c.logValues,
+					"option", unsupportedOption, "value", redactBeegfsClientConfValue(unsupportedOption, val))...)
 			}
 		}
 	}
@@ -199,9 +618,17 @@ func overwriteFileSystemSpecificConfigs(writeTo, writeFrom []fileSystemSpecificC
 // overwriteFrom ONLY overwrites configuration in the receiving beegfsConfig that is also defined in writeFrom, while
 // leaving writeFrom configuration untouched.
 func (c *beegfsConfig) overwriteFrom(writeFrom beegfsConfig) {
-	if len(writeFrom.ConnInterfaces) != 0 {
-		c.ConnInterfaces = make([]string, len(writeFrom.ConnInterfaces))
-		copy(c.ConnInterfaces, writeFrom.ConnInterfaces)
+	// Interfaces specified either way replace interfaces specified either way in less specific configuration.
+	if len(writeFrom.ConnInterfaces) != 0 || len(writeFrom.ConnInterfacesSelectors) != 0 {
+		c.ConnInterfaces, c.ConnInterfacesSelectors = nil, nil
+		if len(writeFrom.ConnInterfaces) != 0 {
+			c.ConnInterfaces = make([]string, len(writeFrom.ConnInterfaces))
+			copy(c.ConnInterfaces, writeFrom.ConnInterfaces)
+		}
+		if len(writeFrom.ConnInterfacesSelectors) != 0 {
+			c.ConnInterfacesSelectors = make([]string, len(writeFrom.ConnInterfacesSelectors))
+			copy(c.ConnInterfacesSelectors, writeFrom.ConnInterfacesSelectors)
+		}
 	}
 	if len(writeFrom.ConnNetFilter) != 0 {
 		c.ConnNetFilter = make([]string, len(writeFrom.ConnNetFilter))
@@ -212,6 +639,51 @@ func (c *beegfsConfig) overwriteFrom(writeFrom beegfsConfig) {
 		copy(c.ConnTcpOnlyFilter, writeFrom.ConnTcpOnlyFilter)
 	}
 	for k, v := range writeFrom.BeegfsClientConf {
+		if c.BeegfsClientConf == nil {
+			c.BeegfsClientConf = make(map[string]string)
+		}
 		c.BeegfsClientConf[k] = v
 	}
+	if writeFrom.AllowEphemeralVolumes != nil {
+		allowEphemeralVolumes := *writeFrom.AllowEphemeralVolumes
+		c.AllowEphemeralVolumes = &allowEphemeralVolumes
+	}
+	// A template specified either way replaces a template specified either way in less specific configuration.
+	if writeFrom.ClientConfTemplatePath != "" || writeFrom.ClientConfTemplate != "" {
+		c.ClientConfTemplatePath = writeFrom.ClientConfTemplatePath
+		c.ClientConfTemplate = writeFrom.ClientConfTemplate
+	}
+	if writeFrom.ConnAuthFile != "" {
+		c.ConnAuthFile = writeFrom.ConnAuthFile
+	}
+	if writeFrom.ConnClientPortUDPRange != "" {
+		c.ConnClientPortUDPRange = writeFrom.ConnClientPortUDPRange
+	}
+	if len(writeFrom.AccessibleTopology) != 0 {
+		c.AccessibleTopology = make([]map[string]string, len(writeFrom.AccessibleTopology))
+		for i, segments := range writeFrom.AccessibleTopology {
+			c.AccessibleTopology[i] = make(map[string]string, len(segments))
+			for k, v := range segments {
+				c.AccessibleTopology[i][k] = v
+			}
+		}
+	}
+}
+
+// overwriteFrom ONLY overwrites node information in the receiving nodeInfoConfig that is also defined in writeFrom.
+// Topology segments are merged key by key.
+func (n *nodeInfoConfig) overwriteFrom(writeFrom nodeInfoConfig) {
+	if writeFrom.MaxVolumesPerNode != 0 {
+		n.MaxVolumesPerNode = writeFrom.MaxVolumesPerNode
+	}
+	if len(writeFrom.TopologySegments) != 0 {
+		segments := make(map[string]string, len(n.TopologySegments)+len(writeFrom.TopologySegments))
+		for k, v := range n.TopologySegments {
+			segments[k] = v
+		}
+		for k, v := range writeFrom.TopologySegments {
+			segments[k] = v
+		}
+		n.TopologySegments = segments
+	}
 }
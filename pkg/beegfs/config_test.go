@@ -7,6 +7,7 @@ package beegfs
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/pkg/errors"
@@ -114,7 +115,7 @@ func TestParseConfig(t *testing.T) {
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			got, err := parseConfigFromFile(tc.configFile, tc.nodeID)
+			got, err := parseConfigFromFile(tc.configFile, tc.nodeID, nil)
 			if err != nil {
 				t.Error(err)
 			}
@@ -126,7 +127,7 @@ func TestParseConfig(t *testing.T) {
 }
 
 func TestValidateConfig(t *testing.T) {
-	basicConfig, err := parseConfigFromFile("testdata/basic.yaml", "testnode")
+	basicConfig, err := parseConfigFromFile("testdata/basic.yaml", "testnode", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -149,12 +150,72 @@ func TestValidateConfig(t *testing.T) {
 				},
 			},
 		},
+		"sysMgmtdHost with single label upper case host name": {
+			nil,
+			pluginConfig{
+				FileSystemSpecificConfigs: []fileSystemSpecificConfig{
+					{
+						SysMgmtdHost: "MGMTD01",
+					},
+				},
+			},
+		},
 		"invalid sysMgmtdHost": {
-			errors.New("invalid SysMgmtdHost testinvalid"),
+			errors.New("invalid SysMgmtdHost test_invalid"),
+			pluginConfig{
+				FileSystemSpecificConfigs: []fileSystemSpecificConfig{
+					{
+						SysMgmtdHost: "test_invalid",
+					},
+				},
+			},
+		},
+		"allowed volume beegfsClientConf": {
+			nil,
+			pluginConfig{AllowedVolumeBeegfsClientConf: []string{"tuneFileCacheType"}},
+		},
+		"no effect volume beegfsClientConf": {
+			errors.New("beegfsClientConf parameter connClientPortUDP can not be allowed in volume parameters"),
+			pluginConfig{AllowedVolumeBeegfsClientConf: []string{"connClientPortUDP"}},
+		},
+		"sensitive volume beegfsClientConf": {
+			errors.New("beegfsClientConf parameter connAuthFile can not be allowed in volume parameters"),
+			pluginConfig{AllowedVolumeBeegfsClientConf: []string{"connAuthFile"}},
+		},
+		"connInterfaces and connInterfacesSelectors": {
+			errors.New("only one of connInterfaces and connInterfacesSelectors can be specified"),
+			pluginConfig{
+				DefaultConfig: beegfsConfig{
+					ConnInterfaces:          []string{"ib0"},
+					ConnInterfacesSelectors: []string{"ib*"},
+				},
+			},
+		},
+		"invalid connInterfacesSelector": {
+			errors.New("invalid connInterfacesSelector ib["),
+			pluginConfig{
+				DefaultConfig: beegfsConfig{
+					ConnInterfacesSelectors: []string{"10.1.0.0/16", "ib["},
+				},
+			},
+		},
+		"empty accessibleTopology entry": {
+			errors.New("empty accessibleTopology entry"),
+			pluginConfig{
+				DefaultConfig: beegfsConfig{
+					AccessibleTopology: []map[string]string{{}},
+				},
+			},
+		},
+		"invalid accessibleTopology": {
+			errors.New("invalid accessibleTopology: invalid value ib/tcp for topology segment key fabric"),
 			pluginConfig{
 				FileSystemSpecificConfigs: []fileSystemSpecificConfig{
 					{
-						SysMgmtdHost: "testinvalid",
+						SysMgmtdHost: "127.0.0.1",
+						Config: beegfsConfig{
+							AccessibleTopology: []map[string]string{{"fabric": "ib/tcp"}},
+						},
 					},
 				},
 			},
@@ -172,6 +233,20 @@ func TestValidateConfig(t *testing.T) {
 				},
 			},
 		},
+		"clientConfTemplatePath and clientConfTemplate": {
+			errors.New("only one of clientConfTemplatePath and clientConfTemplate can be specified"),
+			pluginConfig{
+				FileSystemSpecificConfigs: []fileSystemSpecificConfig{
+					{
+						SysMgmtdHost: "127.0.0.0",
+						Config: beegfsConfig{
+							ClientConfTemplatePath: "/etc/beegfs/7.2/beegfs-client.conf",
+							ClientConfTemplate:     "sysMgmtdHost =\n",
+						},
+					},
+				},
+			},
+		},
 		"invalid ConnTCPOnlyFilter": {
 			errors.New("invalid ConnTCPOnlyFilter testinvalid"),
 			pluginConfig{
@@ -199,11 +274,11 @@ func TestValidateConfig(t *testing.T) {
 
 // Verifies that stripping a config removes any options marked as "no effect"
 func TestStripNoEffectConfig(t *testing.T) {
-	originalConfig, err := parseConfigFromFile("testdata/basic.yaml", "testnode")
+	originalConfig, err := parseConfigFromFile("testdata/basic.yaml", "testnode", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	modifiedConfig, err := parseConfigFromFile("testdata/basic.yaml", "testnode")
+	modifiedConfig, err := parseConfigFromFile("testdata/basic.yaml", "testnode", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -221,11 +296,11 @@ func TestStripNoEffectConfig(t *testing.T) {
 
 // Verifies that stripping a config without unsupported or no-effect options does nothing to the config
 func TestStripCleanConfig(t *testing.T) {
-	originalConfig, err := parseConfigFromFile("testdata/basic.yaml", "testnode")
+	originalConfig, err := parseConfigFromFile("testdata/basic.yaml", "testnode", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	modifiedConfig, err := parseConfigFromFile("testdata/basic.yaml", "testnode")
+	modifiedConfig, err := parseConfigFromFile("testdata/basic.yaml", "testnode", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -238,11 +313,11 @@ func TestStripCleanConfig(t *testing.T) {
 
 // Verifies that stripping a config with unsupported options does not remove them
 func TestStripUnsupportedConfig(t *testing.T) {
-	originalConfig, err := parseConfigFromFile("testdata/basic.yaml", "testnode")
+	originalConfig, err := parseConfigFromFile("testdata/basic.yaml", "testnode", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	modifiedConfig, err := parseConfigFromFile("testdata/basic.yaml", "testnode")
+	modifiedConfig, err := parseConfigFromFile("testdata/basic.yaml", "testnode", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -281,3 +356,604 @@ func TestOverwriteFromBeegfsClientConfEmptyValue(t *testing.T) {
 		t.Fatalf("expected: %v, got: %v", want, writeTo)
 	}
 }
+
+func TestOverwriteFromAllowEphemeralVolumes(t *testing.T) {
+	allow, deny := true, false
+	tests := map[string]struct {
+		writeTo, writeFrom *bool
+		want               *bool
+	}{
+		"unset does not overwrite": {
+			writeTo:   &allow,
+			writeFrom: nil,
+			want:      &allow,
+		},
+		"false overwrites true": {
+			writeTo:   &allow,
+			writeFrom: &deny,
+			want:      &deny,
+		},
+		"true overwrites unset": {
+			writeTo:   nil,
+			writeFrom: &allow,
+			want:      &allow,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			writeTo := beegfsConfig{AllowEphemeralVolumes: tc.writeTo}
+			writeTo.overwriteFrom(beegfsConfig{AllowEphemeralVolumes: tc.writeFrom})
+			if !reflect.DeepEqual(tc.want, writeTo.AllowEphemeralVolumes) {
+				t.Fatalf("expected: %v, got: %v", tc.want, writeTo.AllowEphemeralVolumes)
+			}
+		})
+	}
+}
+
+// TestParseConfigNodeSelectors uses testdata/node-selectors.yaml. Each of its nodeSpecificConfigs uses a different
+// selector and overrides connMgmtdPort, so the value of connMgmtdPort shows which matching entry was applied last.
+func TestParseConfigNodeSelectors(t *testing.T) {
+	fs = afero.NewOsFs()
+	fsutil = afero.Afero{Fs: fs}
+	allLabels := map[string]string{
+		"topology.kubernetes.io/zone": "zone-a",
+		"beegfs.csi.netapp.com/rdma":  "true",
+		"kubernetes.io/hostname":      "testnode",
+	}
+	tests := map[string]struct {
+		nodeID     string
+		nodeLabels map[string]string
+		want       beegfsConfig
+	}{
+		"all selectors match and later entries take precedence": {
+			nodeID:     "testnode",
+			nodeLabels: allLabels,
+			want: beegfsConfig{
+				ConnInterfaces:    []string{"ib1"},
+				ConnNetFilter:     []string{"127.0.0.2/24"},
+				ConnTcpOnlyFilter: []string{"127.0.0.3"},
+				BeegfsClientConf:  map[string]string{"connMgmtdPort": "8003"},
+			},
+		},
+		"pattern and regex match": {
+			nodeID: "testhost12",
+			want: beegfsConfig{
+				ConnInterfaces:    []string{"ib1"},
+				ConnNetFilter:     []string{"127.0.0.2/24"},
+				ConnTcpOnlyFilter: []string{"127.0.0.0"},
+				BeegfsClientConf:  map[string]string{"connMgmtdPort": "8002"},
+			},
+		},
+		"only pattern matches": {
+			nodeID: "testvm",
+			want: beegfsConfig{
+				ConnInterfaces:    []string{"ib1"},
+				ConnNetFilter:     []string{"127.0.0.0/24"},
+				ConnTcpOnlyFilter: []string{"127.0.0.0"},
+				BeegfsClientConf:  map[string]string{"connMgmtdPort": "8001"},
+			},
+		},
+		"regex must match entire node ID": {
+			nodeID: "prodtestnode",
+			want: beegfsConfig{
+				ConnInterfaces:    []string{"ib0"},
+				ConnNetFilter:     []string{"127.0.0.0/24"},
+				ConnTcpOnlyFilter: []string{"127.0.0.0"},
+				BeegfsClientConf:  map[string]string{"connMgmtdPort": "8000"},
+			},
+		},
+		"only labels match": {
+			nodeID:     "prodnode",
+			nodeLabels: allLabels,
+			want: beegfsConfig{
+				ConnInterfaces:    []string{"ib0"},
+				ConnNetFilter:     []string{"127.0.0.0/24"},
+				ConnTcpOnlyFilter: []string{"127.0.0.3"},
+				BeegfsClientConf:  map[string]string{"connMgmtdPort": "8003"},
+			},
+		},
+		"labels must all match": {
+			nodeID:     "othernode",
+			nodeLabels: map[string]string{"topology.kubernetes.io/zone": "zone-a"},
+			want: beegfsConfig{
+				ConnInterfaces:    []string{"ib4"},
+				ConnNetFilter:     []string{"127.0.0.0/24"},
+				ConnTcpOnlyFilter: []string{"127.0.0.0"},
+				BeegfsClientConf:  map[string]string{"connMgmtdPort": "8000"},
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseConfigFromFile("testdata/node-selectors.yaml", tc.nodeID, tc.nodeLabels)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(tc.want, got.DefaultConfig) {
+				t.Fatalf("expected DefaultConfig: %+v, got DefaultConfig: %+v", tc.want, got.DefaultConfig)
+			}
+		})
+	}
+}
+
+func TestParseConfigInvalidNodeSelectors(t *testing.T) {
+	tests := map[string]string{
+		"invalid pattern": "nodeSpecificConfigs:\n  - nodeNamePatterns:\n      - \"node[\"\n",
+		"invalid regex":   "nodeSpecificConfigs:\n  - nodeNameRegexes:\n      - \"node(\"\n",
+	}
+	for name, rawConfig := range tests {
+		t.Run(name, func(t *testing.T) {
+			// The node ID does not matter. Every nodeSpecificConfig is validated.
+			if _, err := parseConfig([]byte(rawConfig), "testnode", nil); err == nil {
+				t.Fatal("expected error, got none")
+			}
+		})
+	}
+}
+
+func TestParseConfigNodeInfo(t *testing.T) {
+	rawConfig := `nodeInfo:
+  maxVolumesPerNode: 10
+  topologySegments:
+    beegfs.csi.netapp.com/fabric: tcp
+    beegfs.csi.netapp.com/site: a
+nodeSpecificConfigs:
+  - nodeList:
+      - testnode
+    nodeInfo:
+      topologySegments:
+        beegfs.csi.netapp.com/fabric: ib
+  - nodeList:
+      - othernode
+    nodeInfo:
+      maxVolumesPerNode: 20
+`
+	tests := map[string]struct {
+		nodeID string
+		want   nodeInfoConfig
+	}{
+		"segments are merged": {
+			nodeID: "testnode",
+			want: nodeInfoConfig{
+				MaxVolumesPerNode: 10,
+				TopologySegments: map[string]string{
+					"beegfs.csi.netapp.com/fabric": "ib",
+					"beegfs.csi.netapp.com/site":   "a",
+				},
+			},
+		},
+		"max volumes is overridden": {
+			nodeID: "othernode",
+			want: nodeInfoConfig{
+				MaxVolumesPerNode: 20,
+				TopologySegments: map[string]string{
+					"beegfs.csi.netapp.com/fabric": "tcp",
+					"beegfs.csi.netapp.com/site":   "a",
+				},
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseConfig([]byte(rawConfig), tc.nodeID, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(tc.want, got.NodeInfo) {
+				t.Fatalf("expected NodeInfo: %+v, got NodeInfo: %+v", tc.want, got.NodeInfo)
+			}
+		})
+	}
+}
+
+func TestValidateNodeInfoConfig(t *testing.T) {
+	tests := map[string]struct {
+		nodeInfo nodeInfoConfig
+		wantErr  bool
+	}{
+		"empty": {nodeInfo: nodeInfoConfig{}},
+		"valid": {nodeInfo: nodeInfoConfig{
+			MaxVolumesPerNode: 5,
+			TopologySegments:  map[string]string{"beegfs.csi.netapp.com/fabric": "ib", "rack": "r1.a"},
+		}},
+		"negative max volumes": {nodeInfo: nodeInfoConfig{MaxVolumesPerNode: -1}, wantErr: true},
+		"empty prefix":         {nodeInfo: nodeInfoConfig{TopologySegments: map[string]string{"/fabric": "ib"}}, wantErr: true},
+		"uppercase prefix":     {nodeInfo: nodeInfoConfig{TopologySegments: map[string]string{"BeeGFS.com/fabric": "ib"}}, wantErr: true},
+		"invalid name": {
+			nodeInfo: nodeInfoConfig{TopologySegments: map[string]string{"beegfs.csi.netapp.com/-fabric": "ib"}},
+			wantErr:  true,
+		},
+		"name too long": {nodeInfo: nodeInfoConfig{TopologySegments: map[string]string{strings.Repeat("a", 64): "ib"}}, wantErr: true},
+		"empty value":   {nodeInfo: nodeInfoConfig{TopologySegments: map[string]string{"fabric": ""}}, wantErr: true},
+		"invalid value": {nodeInfo: nodeInfoConfig{TopologySegments: map[string]string{"fabric": "ib/tcp"}}, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.nodeInfo.validate()
+			if tc.wantErr && err == nil {
+				t.Fatal("expected error, got none")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadNodeLabels(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	const labelsFilePath = "/etc/podinfo/labels"
+	if err := fsutil.WriteFile(labelsFilePath, []byte("# node labels\nzone=\"zone-a\"\nrack=rack-1\n"),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		labels         string
+		labelsFilePath string
+		want           map[string]string
+		wantErr        bool
+	}{
+		"none": {
+			want: map[string]string{},
+		},
+		"flag": {
+			labels: "zone=zone-b, rdma=true",
+			want:   map[string]string{"zone": "zone-b", "rdma": "true"},
+		},
+		"file": {
+			labelsFilePath: labelsFilePath,
+			want:           map[string]string{"zone": "zone-a", "rack": "rack-1"},
+		},
+		"flag overrides file": {
+			labels:         "zone=zone-b",
+			labelsFilePath: labelsFilePath,
+			want:           map[string]string{"zone": "zone-b", "rack": "rack-1"},
+		},
+		"invalid label": {
+			labels:  "zone",
+			wantErr: true,
+		},
+		"missing file": {
+			labelsFilePath: "/etc/podinfo/missing",
+			wantErr:        true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := LoadNodeLabels(tc.labels, tc.labelsFilePath)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateConfigFileSystems(t *testing.T) {
+	tests := map[string]struct {
+		expectedError error
+		config        pluginConfig
+	}{
+		"valid file systems and remap": {
+			nil,
+			pluginConfig{
+				FileSystems: []namedFileSystem{
+					{Name: "scratch", SysMgmtdHost: "127.0.0.1"},
+					{Name: "home", SysMgmtdHost: "mgmtd.example.com"},
+				},
+				SysMgmtdHostRemap: map[string]string{"10.0.0.1": "scratch", "10.0.0.2": "127.0.0.2"},
+			},
+		},
+		"invalid name": {
+			errors.New("invalid file system name Scratch_1"),
+			pluginConfig{FileSystems: []namedFileSystem{{Name: "Scratch_1", SysMgmtdHost: "127.0.0.1"}}},
+		},
+		"duplicate name": {
+			errors.New("duplicate file system name scratch"),
+			pluginConfig{FileSystems: []namedFileSystem{
+				{Name: "scratch", SysMgmtdHost: "127.0.0.1"},
+				{Name: "scratch", SysMgmtdHost: "127.0.0.2"},
+			}},
+		},
+		"invalid sysMgmtdHost": {
+			errors.New("invalid SysMgmtdHost test_invalid for file system scratch"),
+			pluginConfig{FileSystems: []namedFileSystem{{Name: "scratch", SysMgmtdHost: "test_invalid"}}},
+		},
+		"duplicate sysMgmtdHost": {
+			errors.New("SysMgmtdHost 127.0.0.1 is used by more than one file system"),
+			pluginConfig{FileSystems: []namedFileSystem{
+				{Name: "scratch", SysMgmtdHost: "127.0.0.1"},
+				{Name: "home", SysMgmtdHost: "127.0.0.1"},
+			}},
+		},
+		"invalid remap target": {
+			errors.New("invalid SysMgmtdHostRemap target unknown_fs for 10.0.0.1"),
+			pluginConfig{SysMgmtdHostRemap: map[string]string{"10.0.0.1": "unknown_fs"}},
+		},
+		"name used as sysMgmtdHost of another file system": {
+			errors.New("file system name mgmtd is also used as a SysMgmtdHost"),
+			pluginConfig{FileSystems: []namedFileSystem{
+				{Name: "scratch", SysMgmtdHost: "mgmtd"},
+				{Name: "mgmtd", SysMgmtdHost: "127.0.0.1"},
+			}},
+		},
+		"name used as file system specific sysMgmtdHost": {
+			errors.New("file system name mgmtd is also used as a SysMgmtdHost"),
+			pluginConfig{
+				FileSystemSpecificConfigs: []fileSystemSpecificConfig{{SysMgmtdHost: "mgmtd"}},
+				FileSystems:               []namedFileSystem{{Name: "mgmtd", SysMgmtdHost: "127.0.0.1"}},
+			},
+		},
+		"name used as remapped sysMgmtdHost": {
+			errors.New("file system name mgmtd is also used as a SysMgmtdHost"),
+			pluginConfig{
+				FileSystems:       []namedFileSystem{{Name: "mgmtd", SysMgmtdHost: "127.0.0.1"}},
+				SysMgmtdHostRemap: map[string]string{"mgmtd": "127.0.0.1"},
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.validateConfig()
+			if (err != nil && tc.expectedError == nil) || (err == nil && tc.expectedError != nil) ||
+				(err != nil && tc.expectedError != nil && err.Error() != tc.expectedError.Error()) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestResolveFileSystem(t *testing.T) {
+	config := pluginConfig{
+		FileSystems: []namedFileSystem{
+			{Name: "scratch", SysMgmtdHost: "127.0.0.1"},
+		},
+		SysMgmtdHostRemap: map[string]string{
+			"10.0.0.1": "scratch",
+			"10.0.0.2": "127.0.0.2",
+		},
+	}
+	tests := map[string]struct {
+		fileSystem       string
+		wantSysMgmtdHost string
+		wantFSName       string
+	}{
+		"name":                {"scratch", "127.0.0.1", "scratch"},
+		"sysMgmtdHost":        {"127.0.0.3", "127.0.0.3", ""},
+		"remapped to name":    {"10.0.0.1", "127.0.0.1", "scratch"},
+		"remapped to host":    {"10.0.0.2", "127.0.0.2", ""},
+		"host of a named one": {"127.0.0.1", "127.0.0.1", ""},
+		"bracketed IPv6":      {"[fe80::1]", "fe80::1", ""},
+		"upper case":          {"MGMTD.Example.com:9008", "mgmtd.example.com:9008", ""},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			gotSysMgmtdHost, gotFSName := config.resolveFileSystem(tc.fileSystem)
+			if gotSysMgmtdHost != tc.wantSysMgmtdHost || gotFSName != tc.wantFSName {
+				t.Fatalf("expected: (%s, %s), got: (%s, %s)", tc.wantSysMgmtdHost, tc.wantFSName, gotSysMgmtdHost,
+					gotFSName)
+			}
+		})
+	}
+}
+
+// TestParseConfigFileSystems verifies that the configuration of a named file system is applied like a
+// fileSystemSpecificConfig for its sysMgmtdHost (and can be overridden by node specific configuration).
+func TestParseConfigFileSystems(t *testing.T) {
+	rawConfig := `config:
+  connInterfaces:
+    - ib0
+fileSystems:
+  - name: scratch
+    sysMgmtdHost: 127.0.0.1
+    config:
+      connInterfaces:
+        - ib1
+      beegfsClientConf:
+        connMgmtdPortTCP: "9008"
+nodeSpecificConfigs:
+  - nodeList:
+      - testnode
+    fileSystemSpecificConfigs:
+      - sysMgmtdHost: 127.0.0.1
+        config:
+          connInterfaces:
+            - ib2
+`
+	tests := map[string]struct {
+		nodeID string
+		want   beegfsConfig
+	}{
+		"file system config": {
+			nodeID: "othernode",
+			want: beegfsConfig{
+				ConnInterfaces:   []string{"ib1"},
+				BeegfsClientConf: map[string]string{"connMgmtdPortTCP": "9008"},
+			},
+		},
+		"node specific override": {
+			nodeID: "testnode",
+			want: beegfsConfig{
+				ConnInterfaces:   []string{"ib2"},
+				BeegfsClientConf: map[string]string{"connMgmtdPortTCP": "9008"},
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			config, err := parseConfig([]byte(rawConfig), tc.nodeID, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			sysMgmtdHost, _ := config.resolveFileSystem("scratch")
+			if got := squashConfigForSysMgmtdHost(sysMgmtdHost, config); !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected: %+v, got: %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSplitSysMgmtdHost(t *testing.T) {
+	tests := map[string]struct {
+		sysMgmtdHost       string
+		wantHost, wantPort string
+		wantNormalized     string
+		wantValid          bool
+	}{
+		"ip":                 {"127.0.0.1", "127.0.0.1", "", "127.0.0.1", true},
+		"ip and port":        {"127.0.0.1:9008", "127.0.0.1", "9008", "127.0.0.1:9008", true},
+		"FQDN and port":      {"mgmtd.domain.com:9008", "mgmtd.domain.com", "9008", "mgmtd.domain.com:9008", true},
+		"localhost and port": {"localhost:9008", "localhost", "9008", "localhost:9008", true},
+		"IPv6":               {"fe80::1", "fe80::1", "", "fe80::1", true},
+		"bracketed IPv6":     {"[fe80::1]", "fe80::1", "", "fe80::1", true},
+		"IPv6 and port":      {"[fe80::1]:9008", "fe80::1", "9008", "[fe80::1]:9008", true},
+		"upper case IPv6":    {"[FE80:0::1]:9008", "FE80:0::1", "9008", "[fe80::1]:9008", true},
+		"upper case FQDN":    {"Mgmtd.Example.com", "Mgmtd.Example.com", "", "mgmtd.example.com", true},
+		"port 0":             {"127.0.0.1:0", "", "", "127.0.0.1:0", false},
+		"port out of range":  {"127.0.0.1:65536", "", "", "127.0.0.1:65536", false},
+		"non-numeric port":   {"127.0.0.1:tcp", "", "", "127.0.0.1:tcp", false},
+		"empty port":         {"127.0.0.1:", "", "", "127.0.0.1:", false},
+		"too many colons":    {"mgmtd:9008:9008", "", "", "mgmtd:9008:9008", false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			gotHost, gotPort, err := splitSysMgmtdHost(tc.sysMgmtdHost)
+			if tc.wantValid && err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if gotHost != tc.wantHost || gotPort != tc.wantPort {
+				t.Fatalf("expected: (%s, %s), got: (%s, %s)", tc.wantHost, tc.wantPort, gotHost, gotPort)
+			}
+			if got := normalizeSysMgmtdHost(tc.sysMgmtdHost); got != tc.wantNormalized {
+				t.Fatalf("expected normalized: %s, got: %s", tc.wantNormalized, got)
+			}
+			if got := isValidSysMgmtdHost(tc.sysMgmtdHost); got != tc.wantValid {
+				t.Fatalf("expected valid: %t, got: %t", tc.wantValid, got)
+			}
+		})
+	}
+}
+
+// TestParseConfigSysMgmtdHostPorts verifies that configuration for two file systems on the same host (but different
+// ports) is kept separate and that equivalent forms of the same sysMgmtdHost match each other.
+func TestParseConfigSysMgmtdHostPorts(t *testing.T) {
+	rawConfig := `fileSystemSpecificConfigs:
+  - sysMgmtdHost: 127.0.0.1:9008
+    config:
+      connInterfaces:
+        - ib1
+  - sysMgmtdHost: 127.0.0.1:10008
+    config:
+      connInterfaces:
+        - ib2
+  - sysMgmtdHost: "[fe80::1]"
+    config:
+      connInterfaces:
+        - ib3
+nodeSpecificConfigs:
+  - nodeList:
+      - testnode
+    fileSystemSpecificConfigs:
+      - sysMgmtdHost: fe80::1
+        config:
+          connNetFilter:
+            - 127.0.0.0/24
+`
+	config, err := parseConfig([]byte(rawConfig), "testnode", nil)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	tests := map[string]struct {
+		fileSystem string
+		want       beegfsConfig
+	}{
+		"first port": {
+			fileSystem: "127.0.0.1:9008",
+			want:       beegfsConfig{ConnInterfaces: []string{"ib1"}, BeegfsClientConf: map[string]string{}},
+		},
+		"second port": {
+			fileSystem: "127.0.0.1:10008",
+			want:       beegfsConfig{ConnInterfaces: []string{"ib2"}, BeegfsClientConf: map[string]string{}},
+		},
+		"no port": {
+			fileSystem: "127.0.0.1",
+			want:       beegfsConfig{BeegfsClientConf: map[string]string{}},
+		},
+		"IPv6": {
+			fileSystem: "[fe80::1]",
+			want: beegfsConfig{ConnInterfaces: []string{"ib3"}, ConnNetFilter: []string{"127.0.0.0/24"},
+				BeegfsClientConf: map[string]string{}},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			sysMgmtdHost, _ := config.resolveFileSystem(tc.fileSystem)
+			if got := squashConfigForSysMgmtdHost(sysMgmtdHost, config); !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected: %+v, got: %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestIsValidSysMgmtdHost(t *testing.T) {
+	tests := map[string]struct {
+		sysMgmtdHost string
+		want         bool
+	}{
+		"localhost":                      {"localhost", true},
+		"single label":                   {"mgmtd", true},
+		"upper case":                     {"MGMTD.Example.COM", true},
+		"digits and hyphens":             {"mgmtd-01.3com.example", true},
+		"IPv4":                           {"10.0.0.1", true},
+		"IPv6":                           {"fe80::1", true},
+		"IPv4 mapped IPv6":               {"::ffff:10.0.0.1", true},
+		"bracketed IPv6 and port":        {"[2001:db8::1]:9008", true},
+		"63 character label":             {strings.Repeat("a", 63) + ".example.com", true},
+		"empty":                          {"", false},
+		"underscore":                     {"mgmtd_01", false},
+		"leading hyphen":                 {"-mgmtd", false},
+		"trailing hyphen":                {"mgmtd-.example.com", false},
+		"empty label":                    {"mgmtd..example.com", false},
+		"trailing dot":                   {"mgmtd.example.com.", false},
+		"64 character label":             {strings.Repeat("a", 64) + ".example.com", false},
+		"254 characters":                 {strings.Repeat("a.", 126) + "ab", false},
+		"invalid IPv4":                   {"10.0.0.256", false},
+		"numeric host name":              {"12345", false},
+		"bracketed host name":            {"[mgmtd]:9008", false},
+		"bracketed IPv4":                 {"[10.0.0.1]", false},
+		"IPv6 zone":                      {"fe80::1%eth0", false},
+		"IPv6 and port without brackets": {"fe80::1:9008:x", false},
+		"space":                          {"mgmtd example", false},
+		"URL":                            {"beegfs://mgmtd", false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := isValidSysMgmtdHost(tc.sysMgmtdHost); got != tc.want {
+				t.Fatalf("expected isValidSysMgmtdHost(%q) = %t, got: %t", tc.sysMgmtdHost, tc.want, got)
+			}
+			if !tc.want {
+				return
+			}
+			// A valid sysMgmtdHost must have a valid, canonical normalized form and be usable in a volume ID.
+			normalized := normalizeSysMgmtdHost(tc.sysMgmtdHost)
+			if !isValidSysMgmtdHost(normalized) {
+				t.Fatalf("normalized form %q of valid sysMgmtdHost %q is invalid", normalized, tc.sysMgmtdHost)
+			}
+			if again := normalizeSysMgmtdHost(normalized); again != normalized {
+				t.Fatalf("normalizing %q twice produced %q and %q", tc.sysMgmtdHost, normalized, again)
+			}
+			if _, _, err := parseBeegfsUrl(newBeegfsUrl(tc.sysMgmtdHost, "/")); err != nil {
+				t.Fatalf("failed to parse volume ID for valid sysMgmtdHost %q: %v", tc.sysMgmtdHost, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// checkMountDirPath and checkConnClientPortUDP stand in for values that are only known when a volume is staged.
+const (
+	checkMountDirPath      = "<mountDirPath>"
+	checkConnClientPortUDP = "<selected at mount time>"
+)
+
+// CheckConfig parses and validates the configuration file at configPath the same way the driver running on the node
+// nodeID with nodeLabels would. It then writes to w the beegfsConfig that applies to fileSystem (the name of a file
+// system in the configuration's fileSystems or a sysMgmtdHost) after node specific and file system specific overrides
+// and the client configuration files the driver would render for it from the beegfs-client.conf template at
+// clientConfTemplatePath. Sensitive values are redacted in both. If resolveInterfaces is true, connInterfacesSelectors
+// are resolved against the network interfaces of the machine CheckConfig runs on (e.g. inside a node service Pod) the
+// same way the node service resolves them, and the connInterfaces selected for each file system are written
+// separately. Otherwise they are left unresolved. CheckConfig returns an error if the configuration file is invalid
+// or cannot be applied to the template.
+func CheckConfig(w io.Writer, configPath, nodeID, fileSystem, clientConfTemplatePath string,
+	nodeLabels map[string]string, resolveInterfaces bool) error {
+	var lister interfaceLister
+	if resolveInterfaces {
+		lister = hostInterfaceLister{}
+	}
+	return checkConfig(w, configPath, nodeID, fileSystem, clientConfTemplatePath, nodeLabels, lister)
+}
+
+// checkConfig implements CheckConfig. It resolves connInterfacesSelectors with lister unless lister is nil.
+func checkConfig(w io.Writer, configPath, nodeID, fileSystem, clientConfTemplatePath string,
+	nodeLabels map[string]string, lister interfaceLister) error {
+	if configPath == "" {
+		return errors.New("no configuration file provided")
+	}
+	if fileSystem == "" {
+		return errors.New("no file system name or sysMgmtdHost provided")
+	}
+	pluginConfig, err := parseConfigFromFile(configPath, nodeID, nodeLabels)
+	if err != nil {
+		return errors.WithMessage(err, "failed to handle configuration file")
+	}
+	if err = validateClientConf(pluginConfig, clientConfTemplatePath); err != nil {
+		return errors.WithMessage(err, "configuration file is incompatible with beegfs-client.conf template")
+	}
+	var selected []byte
+	if lister != nil {
+		if err = pluginConfig.resolveConnInterfaces(lister); err != nil {
+			return err
+		}
+		selected = selectedConnInterfaces(pluginConfig)
+	}
+
+	vol := newBeegfsVolume(checkMountDirPath, fileSystem, "/", pluginConfig, nil)
+	configBytes, err := yaml.Marshal(vol.config.redacted())
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal effective configuration")
+	}
+	files, err := renderClientFiles(vol, clientConfTemplatePath, checkConnClientPortUDP)
+	if err != nil {
+		return errors.WithMessage(err, "failed to render client configuration files")
+	}
+
+	sections := []struct {
+		name     string
+		contents []byte
+	}{
+		{name: fmt.Sprintf("effective configuration for node %q and sysMgmtdHost %q", nodeID, vol.sysMgmtdHost),
+			contents: configBytes},
+		{name: "connInterfaces selected from connInterfacesSelectors on this machine", contents: selected},
+		{name: "beegfs-client.conf", contents: []byte(redactText(string(files.clientConf)))},
+		{name: "connInterfacesFile", contents: files.connInterfacesFile},
+		{name: "connNetFilterFile", contents: files.connNetFilterFile},
+		{name: "connTcpOnlyFilterFile", contents: files.connTcpOnlyFilterFile},
+	}
+	for _, section := range sections {
+		if section.contents == nil {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "# %s\n%s\n", section.name, section.contents); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// selectedConnInterfaces describes the connInterfaces resolveConnInterfaces selected for the default configuration
+// and for each file system specific configuration in plConfig that has connInterfacesSelectors. It returns nil if no
+// configuration has connInterfacesSelectors.
+func selectedConnInterfaces(plConfig pluginConfig) []byte {
+	var lines []string
+	describe := func(name string, config beegfsConfig) {
+		if len(config.ConnInterfacesSelectors) == 0 {
+			return
+		}
+		if len(config.ConnInterfaces) == 0 {
+			lines = append(lines, name+": <none; the BeeGFS client will use all interfaces>")
+			return
+		}
+		lines = append(lines, name+": "+strings.Join(config.ConnInterfaces, ", "))
+	}
+	describe("default", plConfig.DefaultConfig)
+	for _, fsConfig := range plConfig.FileSystemSpecificConfigs {
+		describe("sysMgmtdHost "+fsConfig.SysMgmtdHost, fsConfig.Config)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
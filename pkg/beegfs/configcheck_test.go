@@ -0,0 +1,158 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestCheckConfig(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	const (
+		templatePath = "/etc/beegfs/beegfs-client.conf"
+		configPath   = "/config/csi-beegfs-config.yaml"
+	)
+	if err := fsutil.WriteFile(templatePath, []byte(TestWriteClientFilesTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		rawConfig         string
+		nodeID            string
+		sysMgmtdHost      string
+		resolveInterfaces bool     // resolve connInterfacesSelectors against testInterfaces
+		want              []string // lines that must appear in the output
+		wantErr           bool
+	}{
+		"file system and node overrides": {
+			rawConfig: `config:
+  connInterfaces:
+    - ib0
+fileSystemSpecificConfigs:
+  - sysMgmtdHost: 127.0.0.1
+    config:
+      beegfsClientConf:
+        connMgmtdPortTCP: "9008"
+nodeSpecificConfigs:
+  - nodeList:
+      - testnode
+    config:
+      connInterfaces:
+        - ib1
+`,
+			nodeID:       "testnode",
+			sysMgmtdHost: "127.0.0.1",
+			want: []string{
+				`# effective configuration for node "testnode" and sysMgmtdHost "127.0.0.1"`,
+				"- ib1",
+				"connMgmtdPortTCP: \"9008\"",
+				"# beegfs-client.conf",
+				"sysMgmtdHost = 127.0.0.1",
+				"connMgmtdPortTCP = 9008",
+				"connInterfacesFile = <mountDirPath>/connInterfacesFile",
+				"# connInterfacesFile",
+			},
+		},
+		"no matching overrides": {
+			rawConfig: `config:
+  connInterfaces:
+    - ib0
+fileSystemSpecificConfigs:
+  - sysMgmtdHost: 127.0.0.1
+    config:
+      beegfsClientConf:
+        connMgmtdPortTCP: "9008"
+`,
+			nodeID:       "othernode",
+			sysMgmtdHost: "127.0.0.2",
+			want: []string{
+				"- ib0",
+				"sysMgmtdHost = 127.0.0.2",
+				"connMgmtdPortTCP = 8008",
+			},
+		},
+		"interface selectors are not resolved": {
+			rawConfig:    "config:\n  connInterfacesSelectors:\n    - 10.1.0.0/16\n    - ib*\n",
+			sysMgmtdHost: "127.0.0.1",
+			want:         []string{"connInterfacesSelectors:\n- 10.1.0.0/16\n- ib*", "connInterfacesFile = "},
+		},
+		"interface selectors": {
+			rawConfig: `config:
+  connInterfacesSelectors:
+    - 10.1.0.0/16
+    - ib*
+fileSystemSpecificConfigs:
+  - sysMgmtdHost: 127.0.0.2
+    config:
+      connInterfacesSelectors:
+        - eth*
+`,
+			sysMgmtdHost:      "127.0.0.1",
+			resolveInterfaces: true,
+			want: []string{
+				"# connInterfaces selected from connInterfacesSelectors on this machine",
+				"default: ib0, ib1\nsysMgmtdHost 127.0.0.2: eth0",
+				"connInterfacesFile = <mountDirPath>/connInterfacesFile",
+				"# connInterfacesFile\nib0\nib1",
+			},
+		},
+		"interface selectors matching nothing": {
+			rawConfig:         "config:\n  connInterfacesSelectors:\n    - ens*\n",
+			sysMgmtdHost:      "127.0.0.1",
+			resolveInterfaces: true,
+			want:              []string{"default: <none; the BeeGFS client will use all interfaces>"},
+		},
+		"invalid config": {
+			rawConfig:    "config:\n  unknownKey: value\n",
+			sysMgmtdHost: "127.0.0.1",
+			wantErr:      true,
+		},
+		"key not in template": {
+			rawConfig:    "config:\n  beegfsClientConf:\n    notAKey: value\n",
+			sysMgmtdHost: "127.0.0.1",
+			wantErr:      true,
+		},
+		"no sysMgmtdHost": {
+			rawConfig: "config:\n  connInterfaces:\n    - ib0\n",
+			wantErr:   true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := fsutil.WriteFile(configPath, []byte(tc.rawConfig), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			var lister interfaceLister
+			if tc.resolveInterfaces {
+				lister = &fakeInterfaceLister{ifaces: testInterfaces}
+			}
+			err := checkConfig(&out, configPath, tc.nodeID, tc.sysMgmtdHost, templatePath, nil, lister)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got none and output:\n%s", out.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			// beegfs-client.conf values are aligned based on the longest key, so ignore repeated spaces.
+			got := regexp.MustCompile(` +`).ReplaceAllString(out.String(), " ")
+			for _, line := range tc.want {
+				if !strings.Contains(got, line+"\n") {
+					t.Errorf("expected output to contain %q, got:\n%s", line, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// configWatchInterval is how often the configuration file is checked for changes.
+const configWatchInterval = 10 * time.Second
+
+// pluginConfigStore holds the pluginConfig the controller and node services use. The configuration can be replaced
+// while RPCs are running. Each RPC should load the configuration once and use the result throughout so that it never
+// mixes values from different generations.
+type pluginConfigStore struct {
+	value atomic.Value // always contains a *loadedPluginConfig
+	mutex sync.Mutex   // serializes calls to store
+}
+
+// loadedPluginConfig is a pluginConfig and its generation. The first configuration is generation 1 and each
+// replacement increments the generation.
+type loadedPluginConfig struct {
+	config     pluginConfig
+	generation int64
+}
+
+func newPluginConfigStore(config pluginConfig) *pluginConfigStore {
+	s := new(pluginConfigStore)
+	s.value.Store(&loadedPluginConfig{config: config, generation: 1})
+	configGeneration.Set(1)
+	return s
+}
+
+// load returns the current configuration.
+func (s *pluginConfigStore) load() pluginConfig {
+	return s.value.Load().(*loadedPluginConfig).config
+}
+
+// loadWithGeneration returns the current configuration and its generation.
+func (s *pluginConfigStore) loadWithGeneration() (pluginConfig, int64) {
+	loaded := s.value.Load().(*loadedPluginConfig)
+	return loaded.config, loaded.generation
+}
+
+// generation returns the generation of the current configuration.
+func (s *pluginConfigStore) generation() int64 {
+	return s.value.Load().(*loadedPluginConfig).generation
+}
+
+// store replaces the current configuration with config and returns config's generation.
+func (s *pluginConfigStore) store(config pluginConfig) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	generation := s.generation() + 1
+	s.value.Store(&loadedPluginConfig{config: config, generation: generation})
+	configGeneration.Set(float64(generation))
+	return generation
+}
+
+// configWatcher periodically rereads the configuration file and stores it in a pluginConfigStore if it has changed
+// and is valid (see parseConfig and validateClientConf). It reads the file by path each time (instead of relying on
+// file system notifications) so that it notices when Kubernetes updates a mounted ConfigMap by atomically swapping a
+// symlink.
+type configWatcher struct {
+	path                   string
+	nodeID                 string
+	clientConfTemplatePath string
+	nodeLabels             map[string]string
+	store                  *pluginConfigStore
+	interval               time.Duration
+	// lastSum is the checksum of the contents last read from path, whether or not they were valid.
+	lastSum [sha256.Size]byte
+}
+
+// newConfigWatcher returns a configWatcher for a store initialized from rawConfigBytes, the current contents of the
+// configuration file at path.
+func newConfigWatcher(path, nodeID, clientConfTemplatePath string, nodeLabels map[string]string,
+	store *pluginConfigStore, rawConfigBytes []byte) *configWatcher {
+	return &configWatcher{
+		path:                   path,
+		nodeID:                 nodeID,
+		clientConfTemplatePath: clientConfTemplatePath,
+		nodeLabels:             nodeLabels,
+		store:                  store,
+		interval:               configWatchInterval,
+		lastSum:                sha256.Sum256(rawConfigBytes),
+	}
+}
+
+// run checks the configuration file once every interval. It never returns.
+func (w *configWatcher) run() {
+	for {
+		time.Sleep(w.interval)
+		w.checkOnce()
+	}
+}
+
+// checkOnce reloads the configuration file if its contents have changed since the last check. If the new
+// configuration is invalid, checkOnce logs an error and the current configuration remains in use. An invalid
+// configuration is only reported once (until the file changes again).
+func (w *configWatcher) checkOnce() {
+	rawConfigBytes, err := fsutil.ReadFile(w.path)
+	if err != nil {
+		klog.ErrorS(err, "Failed to read configuration file", "path", w.path, "generation", w.store.generation())
+		configReloadsTotal.WithLabelValues("failure").Inc()
+		return
+	}
+	sum := sha256.Sum256(rawConfigBytes)
+	if sum == w.lastSum {
+		return
+	}
+	w.lastSum = sum
+
+	config, err := parseConfig(rawConfigBytes, w.nodeID, w.nodeLabels)
+	if err == nil {
+		err = validateClientConf(config, w.clientConfTemplatePath)
+	}
+	if err != nil {
+		klog.ErrorS(err, "Ignoring invalid configuration file", "path", w.path, "generation",
+			w.store.generation())
+		configReloadsTotal.WithLabelValues("failure").Inc()
+		return
+	}
+	generation := w.store.store(config)
+	klog.InfoS("Reloaded configuration file", "path", w.path, "generation", generation)
+	configReloadsTotal.WithLabelValues("success").Inc()
+}
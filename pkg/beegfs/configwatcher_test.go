@@ -0,0 +1,154 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// setUpConfigWatcher writes rawConfig to configPath (and a beegfs-client.conf template to the same directory) and
+// returns a configWatcher and pluginConfigStore initialized from it.
+func setUpConfigWatcher(t *testing.T, configPath, rawConfig string) (*configWatcher, *pluginConfigStore) {
+	if err := fsutil.WriteFile(configPath, []byte(rawConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clientConfTemplatePath := path.Join(path.Dir(configPath), "beegfs-client.conf")
+	if err := fsutil.WriteFile(clientConfTemplatePath, []byte(TestWriteClientFilesTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := parseConfig([]byte(rawConfig), "testnode", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newPluginConfigStore(config)
+	return newConfigWatcher(configPath, "testnode", clientConfTemplatePath, nil, store, []byte(rawConfig)), store
+}
+
+func TestConfigWatcherCheckOnce(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	const configPath = "/config/csi-beegfs-config.yaml"
+	watcher, store := setUpConfigWatcher(t, configPath, "config:\n  connInterfaces:\n    - ib0\n")
+
+	tests := []struct {
+		name           string
+		rawConfig      string
+		wantGeneration int64
+		wantInterfaces []string
+	}{
+		{
+			name:           "unchanged",
+			rawConfig:      "config:\n  connInterfaces:\n    - ib0\n",
+			wantGeneration: 1,
+			wantInterfaces: []string{"ib0"},
+		},
+		{
+			name:           "valid change",
+			rawConfig:      "config:\n  connInterfaces:\n    - ib1\n",
+			wantGeneration: 2,
+			wantInterfaces: []string{"ib1"},
+		},
+		{
+			name:           "invalid change",
+			rawConfig:      "config:\n  connInterfaces:\n    - ib2\n  unknownKey: value\n",
+			wantGeneration: 2,
+			wantInterfaces: []string{"ib1"},
+		},
+		{
+			name:           "invalid change unchanged",
+			rawConfig:      "config:\n  connInterfaces:\n    - ib2\n  unknownKey: value\n",
+			wantGeneration: 2,
+			wantInterfaces: []string{"ib1"},
+		},
+		{
+			name:           "key not in template",
+			rawConfig:      "config:\n  connInterfaces:\n    - ib2\n  beegfsClientConf:\n    notAKey: value\n",
+			wantGeneration: 2,
+			wantInterfaces: []string{"ib1"},
+		},
+		{
+			name:           "fixed change",
+			rawConfig:      "config:\n  connInterfaces:\n    - ib2\n",
+			wantGeneration: 3,
+			wantInterfaces: []string{"ib2"},
+		},
+	}
+	// These test cases must run in order.
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := fsutil.WriteFile(configPath, []byte(tc.rawConfig), 0644); err != nil {
+				t.Fatal(err)
+			}
+			watcher.checkOnce()
+			if got := store.generation(); got != tc.wantGeneration {
+				t.Fatalf("expected generation %d, got: %d", tc.wantGeneration, got)
+			}
+			if got := store.load().DefaultConfig.ConnInterfaces; !reflect.DeepEqual(tc.wantInterfaces, got) {
+				t.Fatalf("expected connInterfaces %v, got: %v", tc.wantInterfaces, got)
+			}
+		})
+	}
+
+	// A configuration file that disappears leaves the current configuration in place.
+	if err := fs.Remove(configPath); err != nil {
+		t.Fatal(err)
+	}
+	watcher.checkOnce()
+	if got := store.generation(); got != 3 {
+		t.Fatalf("expected generation 3 after configuration file was removed, got: %d", got)
+	}
+}
+
+// TestConfigWatcherConfigMapUpdate simulates the way Kubernetes updates a mounted ConfigMap. The configuration file is
+// a symlink through a ..data symlink to a timestamped directory. An update writes a new timestamped directory and
+// atomically replaces the ..data symlink.
+func TestConfigWatcherConfigMapUpdate(t *testing.T) {
+	fs = afero.NewOsFs() // symlinks are not supported by afero.MemMapFs
+	fsutil = afero.Afero{Fs: fs}
+	configMapDir, err := ioutil.TempDir("", "configmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configMapDir)
+
+	writeConfigMap := func(version, rawConfig string) {
+		if err := os.Mkdir(path.Join(configMapDir, version), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(configMapDir, version, "csi-beegfs-config.yaml"), []byte(rawConfig),
+			0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(version, path.Join(configMapDir, "..data_tmp")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(path.Join(configMapDir, "..data_tmp"), path.Join(configMapDir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const rawConfig = "config:\n  connInterfaces:\n    - ib0\n"
+	writeConfigMap("..2021_01_01", rawConfig)
+	configPath := path.Join(configMapDir, "csi-beegfs-config.yaml")
+	if err := os.Symlink("..data/csi-beegfs-config.yaml", configPath); err != nil {
+		t.Fatal(err)
+	}
+	watcher, store := setUpConfigWatcher(t, configPath, rawConfig)
+
+	writeConfigMap("..2021_01_02", "config:\n  connInterfaces:\n    - ib1\n")
+	watcher.checkOnce()
+	if got := store.generation(); got != 2 {
+		t.Fatalf("expected generation 2, got: %d", got)
+	}
+	if got := store.load().DefaultConfig.ConnInterfaces; !reflect.DeepEqual([]string{"ib1"}, got) {
+		t.Fatalf("expected connInterfaces [ib1], got: %v", got)
+	}
+}
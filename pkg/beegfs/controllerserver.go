@@ -22,15 +22,16 @@ Licensed under the Apache License, Version 2.0.
 package beegfs
 
 import (
+	"os"
 	"path"
 	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
 	"k8s.io/utils/mount"
 )
 
@@ -45,13 +46,14 @@ type controllerServer struct {
 	ctlExec                beegfsCtlExecutorInterface
 	caps                   []*csi.ControllerServiceCapability
 	nodeID                 string
-	pluginConfig           pluginConfig
+	configStore            *pluginConfigStore
 	clientConfTemplatePath string
 	mounter                mount.Interface
 	csDataDir              string
+	udpPorts               *udpPortAllocator
 }
 
-func NewControllerServer(nodeID string, pluginConfig pluginConfig, clientConfTemplatePath, csDataDir string) *controllerServer {
+func NewControllerServer(nodeID string, configStore *pluginConfigStore, clientConfTemplatePath, csDataDir string) *controllerServer {
 	return &controllerServer{
 		ctlExec: &beegfsCtlExecutor{},
 		caps: getControllerServiceCapabilities(
@@ -59,9 +61,10 @@ func NewControllerServer(nodeID string, pluginConfig pluginConfig, clientConfTem
 				csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 			}),
 		nodeID:                 nodeID,
-		pluginConfig:           pluginConfig,
+		configStore:            configStore,
 		clientConfTemplatePath: clientConfTemplatePath,
 		csDataDir:              csDataDir,
+		udpPorts:               newUDPPortAllocator(csDataDir),
 		mounter:                nil,
 	}
 }
@@ -86,9 +89,10 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	if len(reqParams) == 0 {
 		return nil, status.Errorf(codes.InvalidArgument, "Request parameters not provided")
 	}
-	sysMgmtdHost, ok := reqParams[sysMgmtdHostKey]
-	if !ok {
-		return nil, status.Errorf(codes.InvalidArgument, "%s not provided", sysMgmtdHostKey)
+	pluginConfig := cs.configStore.load()
+	fileSystem, err := getFileSystemFromParams(reqParams, pluginConfig)
+	if err != nil {
+		return nil, newGrpcErrorFromCause(codes.InvalidArgument, err)
 	}
 	volDirBasePathBeegfsRoot, ok := reqParams[volDirBasePathKey]
 	if !ok {
@@ -99,34 +103,50 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	if err != nil {
 		return nil, newGrpcErrorFromCause(codes.InvalidArgument, err)
 	}
+	volBeegfsClientConf, err := getBeegfsClientConfFromParams(reqParams, pluginConfig)
+	if err != nil {
+		return nil, newGrpcErrorFromCause(codes.InvalidArgument, err)
+	}
+	transport, err := getTransportFromParams(reqParams)
+	if err != nil {
+		return nil, newGrpcErrorFromCause(codes.InvalidArgument, err)
+	}
 
-	vol := cs.newBeegfsVolume(sysMgmtdHost, volDirBasePathBeegfsRoot, volName)
+	vol := cs.newBeegfsVolume(fileSystem, volDirBasePathBeegfsRoot, volName, pluginConfig, volBeegfsClientConf)
+	vol.connAuth = connAuthFromSecrets(req.GetSecrets())
+	volTopologies := volumeTopology(vol.config)
+	if !isAccessibleFrom(volTopologies, req.GetAccessibilityRequirements()) {
+		return nil, status.Errorf(codes.ResourceExhausted,
+			"BeeGFS file system %s is not reachable from any requested topology", vol.sysMgmtdHost)
+	}
 
 	// Write configuration files but do not mount BeeGFS.
 	defer func() {
 		// Failure to clean up is an internal problem. The CO only cares whether or not we created the volume.
-		if err := cleanUpIfNecessary(vol, true); err != nil {
-			glog.Warningf("Failed to clean up %s for %s: %+v", vol.mountDirPath, vol.volumeID, err)
+		if err := cleanUpIfNecessary(ctx, vol, true); err != nil {
+			newVolumeLogger(ctx, vol).Error(err, "Failed to clean up volume files", "path", vol.mountDirPath)
 		}
 	}()
 	if err := fs.MkdirAll(vol.mountDirPath, 0750); err != nil {
 		err = errors.WithStack(err)
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
 	}
-	if err := writeClientFiles(vol, cs.clientConfTemplatePath); err != nil {
-		return nil, newGrpcErrorFromCause(codes.Internal, err)
+	if err := writeClientFiles(ctx, vol, cs.clientConfTemplatePath, cs.udpPorts); err != nil {
+		return nil, newGrpcErrorFromCause(clientFilesErrorCode(err), err)
 	}
 
-	if err := cs.ctlExec.createDirectoryForVolume(vol); err != nil {
+	if err := cs.ctlExec.createDirectoryForVolume(ctx, vol); err != nil {
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
 	}
-	if err := cs.ctlExec.setPatternForVolume(vol, stripePatternConfig); err != nil {
+	if err := cs.ctlExec.setPatternForVolume(ctx, vol, stripePatternConfig); err != nil {
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
 	}
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			VolumeId: vol.volumeID,
+			VolumeId:           vol.volumeID,
+			VolumeContext:      newVolumeContext(volBeegfsClientConf, transport),
+			AccessibleTopology: volTopologies,
 		},
 	}, nil
 }
@@ -144,27 +164,28 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 	if err != nil {
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
 	}
+	vol.connAuth = connAuthFromSecrets(req.GetSecrets())
 
 	// Write configuration files and mount BeeGFS.
 	defer func() {
 		// Failure to clean up is an internal problem. The CO only cares whether or not we deleted the volume.
-		if err := unmountAndCleanUpIfNecessary(vol, true, cs.mounter); err != nil {
-			glog.Warningf("Failed to clean up %s for %s: %+v", vol.mountDirPath, vol.volumeID, err)
+		if err := unmountAndCleanUpIfNecessary(ctx, vol, true, cs.mounter); err != nil {
+			newVolumeLogger(ctx, vol).Error(err, "Failed to clean up volume files", "path", vol.mountDirPath)
 		}
 	}()
 	if err := fs.MkdirAll(vol.mountDirPath, 0750); err != nil {
 		err = errors.WithStack(err)
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
 	}
-	if err := writeClientFiles(vol, cs.clientConfTemplatePath); err != nil {
-		return nil, newGrpcErrorFromCause(codes.Internal, err)
+	if err := writeClientFiles(ctx, vol, cs.clientConfTemplatePath, cs.udpPorts); err != nil {
+		return nil, newGrpcErrorFromCause(clientFilesErrorCode(err), err)
 	}
-	if err := mountIfNecessary(vol, cs.mounter); err != nil {
+	if err := mountIfNecessary(ctx, vol, nil, cs.mounter); err != nil {
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
 	}
 
 	// Delete volume from mounted BeeGFS.
-	glog.V(LogDebug).Infof("Deleting BeeGFS directory %s for %s", vol.volDirBasePathBeegfsRoot, vol.volumeID)
+	newVolumeLogger(ctx, vol).V(LogDebug).Info("Deleting BeeGFS directory", "path", vol.volDirPathBeegfsRoot)
 	if err = fs.RemoveAll(vol.volDirPath); err != nil {
 		err = errors.WithStack(err)
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
@@ -203,23 +224,24 @@ func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 	if err != nil {
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
 	}
+	vol.connAuth = connAuthFromSecrets(req.GetSecrets())
 
 	// Write configuration files but do not mount BeeGFS.
 	defer func() {
 		// Failure to clean up is an internal problem. The CO only cares whether or not the volume exists.
-		if err := cleanUpIfNecessary(vol, true); err != nil {
-			glog.Warningf("Failed to clean up %s for %s: %+v", vol.mountDirPath, vol.volumeID, err)
+		if err := cleanUpIfNecessary(ctx, vol, true); err != nil {
+			newVolumeLogger(ctx, vol).Error(err, "Failed to clean up volume files", "path", vol.mountDirPath)
 		}
 	}()
 	if err := fs.MkdirAll(vol.mountDirPath, 0750); err != nil {
 		err = errors.WithStack(err)
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
 	}
-	if err := writeClientFiles(vol, cs.clientConfTemplatePath); err != nil {
-		return nil, newGrpcErrorFromCause(codes.Internal, err)
+	if err := writeClientFiles(ctx, vol, cs.clientConfTemplatePath, cs.udpPorts); err != nil {
+		return nil, newGrpcErrorFromCause(clientFilesErrorCode(err), err)
 	}
 
-	if _, err := cs.ctlExec.statDirectoryForVolume(vol); err != nil {
+	if _, err := cs.ctlExec.statDirectoryForVolume(ctx, vol); err != nil {
 		if errors.As(err, &ctlNotExistError{}) {
 			return nil, newGrpcErrorFromCause(codes.NotFound, err)
 		}
@@ -283,7 +305,7 @@ func getControllerServiceCapabilities(cl []csi.ControllerServiceCapability_RPC_T
 	var csc []*csi.ControllerServiceCapability
 
 	for _, cap := range cl {
-		glog.V(LogDebug).Infof("Enabling controller service capability: %v", cap.String())
+		klog.V(LogDebug).InfoS("Enabling controller service capability", "capability", cap.String())
 		csc = append(csc, &csi.ControllerServiceCapability{
 			Type: &csi.ControllerServiceCapability_Rpc{
 				Rpc: &csi.ControllerServiceCapability_RPC{
@@ -317,15 +339,16 @@ func getStripePatternParamsFromRequest(reqParams map[string]string) (stripePatte
 }
 
 // (*controllerServer) newBeegfsVolume is a wrapper around newBeegfsVolume that makes it easier to call in the context
-// of the controller service. (*controllerServer) newBeegfsVolume selects the mountDirPath and passes the controller
-//service's pluginConfig.
-func (cs *controllerServer) newBeegfsVolume(sysMgmtdHost, volDirBasePathBeegfsRoot, volName string) beegfsVolume {
+// of the controller service. (*controllerServer) newBeegfsVolume selects the mountDirPath. The caller passes the
+// pluginConfig it loaded so that it can use the same configuration to validate fileSystem.
+func (cs *controllerServer) newBeegfsVolume(fileSystem, volDirBasePathBeegfsRoot, volName string,
+	pluginConfig pluginConfig, volBeegfsClientConf map[string]string) beegfsVolume {
 	volDirPathBeegfsRoot := path.Join(volDirBasePathBeegfsRoot, volName)
 	// This volumeID construction duplicates the one further down in the stack. We do it anyway to generate an
 	// appropriate mountDirPath.
-	volumeID := newBeegfsUrl(sysMgmtdHost, volDirPathBeegfsRoot)
+	volumeID := newBeegfsUrl(fileSystem, volDirPathBeegfsRoot)
 	mountDirPath := path.Join(cs.csDataDir, sanitizeVolumeID(volumeID)) // e.g. /csDataDir/127.0.0.1_scratch_pvc-12345678
-	return newBeegfsVolume(mountDirPath, sysMgmtdHost, volDirPathBeegfsRoot, cs.pluginConfig)
+	return newBeegfsVolume(mountDirPath, fileSystem, volDirPathBeegfsRoot, pluginConfig, volBeegfsClientConf)
 }
 
 // (*controllerServer) newBeegfsVolumeFromID is a wrapper around newBeegfsVolumeFromID that makes it easier to call in
@@ -333,5 +356,110 @@ func (cs *controllerServer) newBeegfsVolume(sysMgmtdHost, volDirBasePathBeegfsRo
 // the controller service's pluginConfig.
 func (cs *controllerServer) newBeegfsVolumeFromID(volumeID string) (beegfsVolume, error) {
 	mountDirPath := path.Join(cs.csDataDir, sanitizeVolumeID(volumeID)) // e.g. /csDataDir/127.0.0.1_scratch_pvc-12345678
-	return newBeegfsVolumeFromID(mountDirPath, volumeID, cs.pluginConfig)
+	return newBeegfsVolumeFromID(mountDirPath, volumeID, cs.configStore.load(), nil)
+}
+
+// newVolumeContext returns the volume context that passes the beegfsClientConf parameters (see
+// getBeegfsClientConfFromParams) and the transport (see getTransportFromParams) a volume was created with to
+// NodeStageVolume.
+func newVolumeContext(volBeegfsClientConf map[string]string, transport string) map[string]string {
+	if len(volBeegfsClientConf) == 0 && transport == "" {
+		return nil
+	}
+	volContext := make(map[string]string, len(volBeegfsClientConf)+1)
+	for k, v := range volBeegfsClientConf {
+		volContext[beegfsClientConfKeyPrefix+k] = v
+	}
+	if transport != "" {
+		volContext[transportKey] = transport
+	}
+	return volContext
+}
+
+// dataDirSweepResult summarizes what sweepDataDir reclaimed (or would have reclaimed in dry-run mode).
+type dataDirSweepResult struct {
+	unmounted int // BeeGFS file systems unmounted
+	removed   int // directories removed
+	failed    int // directories that could not be cleaned up
+}
+
+// sweepDataDir cleans up BeeGFS file systems and client configuration files left in csDataDir by a controller service
+// that exited in the middle of an RPC (e.g. DeleteVolume). It must only be called before the controller service starts
+// to serve requests. Only directories that look like a mountDirPath (they contain a beegfs-client.conf file or a mount
+// directory) are considered and the node service's ephemeral volume directory is never touched. If dryRun is true,
+// sweepDataDir only logs what it would do. sweepDataDir logs failures to clean up individual directories instead of
+// returning them.
+func (cs *controllerServer) sweepDataDir(dryRun bool) (result dataDirSweepResult, err error) {
+	entries, err := fsutil.ReadDir(cs.csDataDir)
+	if err != nil {
+		return result, errors.WithStack(err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == ephemeralDirName {
+			continue
+		}
+		mountDirPath := path.Join(cs.csDataDir, entry.Name())
+		mountPath := path.Join(mountDirPath, "mount")
+		_, confErr := fs.Stat(path.Join(mountDirPath, "beegfs-client.conf"))
+		_, mountErr := fs.Stat(mountPath) // A corrupted mount does not return an IsNotExist error.
+		if os.IsNotExist(confErr) && os.IsNotExist(mountErr) {
+			continue
+		}
+
+		mounted, err := cs.isMounted(mountPath)
+		if err != nil {
+			klog.ErrorS(err, "Failed to determine whether stale BeeGFS file system is mounted", "path", mountPath)
+			result.failed++
+			continue
+		}
+		if dryRun {
+			if mounted {
+				klog.InfoS("Dry run: would unmount stale BeeGFS file system", "path", mountPath)
+				result.unmounted++
+			}
+			klog.InfoS("Dry run: would remove stale directory", "path", mountDirPath)
+			result.removed++
+			continue
+		}
+
+		if mounted {
+			klog.InfoS("Unmounting stale BeeGFS file system", "path", mountPath)
+			if err := cs.mounter.Unmount(mountPath); err != nil {
+				klog.ErrorS(err, "Failed to unmount stale BeeGFS file system", "path", mountPath)
+				result.failed++
+				continue
+			}
+			// Never remove a directory with a file system still mounted inside it. Doing so would delete the
+			// contents of the file system.
+			if mounted, err = cs.isMounted(mountPath); err != nil || mounted {
+				klog.ErrorS(err, "Stale BeeGFS file system is still mounted after unmount", "path", mountPath)
+				result.failed++
+				continue
+			}
+			result.unmounted++
+		}
+		klog.InfoS("Removing stale directory", "path", mountDirPath)
+		if err := fs.RemoveAll(mountDirPath); err != nil {
+			klog.ErrorS(err, "Failed to remove stale directory", "path", mountDirPath)
+			result.failed++
+			continue
+		}
+		result.removed++
+	}
+	return result, nil
+}
+
+// isMounted returns true if a file system (possibly a corrupted one) is mounted at mountPath.
+func (cs *controllerServer) isMounted(mountPath string) (bool, error) {
+	notMnt, err := cs.mounter.IsLikelyNotMountPoint(mountPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		if mount.IsCorruptedMnt(err) {
+			return true, nil
+		}
+		return false, errors.WithStack(err)
+	}
+	return !notMnt, nil
 }
@@ -6,8 +6,19 @@ Licensed under the Apache License, Version 2.0.
 package beegfs
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
 	"reflect"
 	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/mount"
 )
 
 func TestGetStripePatternParamsFromRequest(t *testing.T) {
@@ -108,3 +119,154 @@ func TestGetStripePatternParamsFromRequest(t *testing.T) {
 	}
 
 }
+
+func TestSweepDataDir(t *testing.T) {
+	fs = afero.NewOsFs() // mount.FakeMounter uses the real file system to check mount points
+	fsutil = afero.Afero{Fs: fs}
+
+	tests := map[string]struct {
+		dryRun      bool
+		wantResult  dataDirSweepResult
+		wantRemain  []string
+		wantActions []mount.FakeAction
+	}{
+		"dry run": {
+			dryRun:     true,
+			wantResult: dataDirSweepResult{unmounted: 1, removed: 2},
+			wantRemain: []string{"127.0.0.1_scratch_pvc-1", "127.0.0.1_scratch_pvc-2", "csi.sock", ephemeralDirName,
+				"unrelated"},
+		},
+		"enabled": {
+			wantResult: dataDirSweepResult{unmounted: 1, removed: 2},
+			wantRemain: []string{"csi.sock", ephemeralDirName, "unrelated"},
+			wantActions: []mount.FakeAction{
+				{Action: mount.FakeActionUnmount, Target: "127.0.0.1_scratch_pvc-1/mount"},
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			csDataDir, err := ioutil.TempDir("", "sweep-data-dir")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(csDataDir)
+
+			// 127.0.0.1_scratch_pvc-1 is mounted, 127.0.0.1_scratch_pvc-2 is not, and the rest do not belong to the
+			// controller service.
+			for _, dir := range []string{"127.0.0.1_scratch_pvc-1/mount", "127.0.0.1_scratch_pvc-2",
+				ephemeralDirName + "/csi-0123456789abcdef/mount", "unrelated"} {
+				if err := fs.MkdirAll(path.Join(csDataDir, dir), 0750); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, file := range []string{"127.0.0.1_scratch_pvc-1/beegfs-client.conf",
+				"127.0.0.1_scratch_pvc-2/beegfs-client.conf", "csi.sock"} {
+				if err := fsutil.WriteFile(path.Join(csDataDir, file), []byte{}, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			mounter := mount.NewFakeMounter([]mount.MountPoint{
+				{Device: "beegfs_nodev", Path: path.Join(csDataDir, "127.0.0.1_scratch_pvc-1/mount"), Type: "beegfs"},
+			})
+			cs := NewControllerServer("testnode", newPluginConfigStore(pluginConfig{}), "/etc/beegfs/beegfs-client.conf", csDataDir)
+			cs.mounter = mounter
+
+			result, err := cs.sweepDataDir(tc.dryRun)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(tc.wantResult, result) {
+				t.Fatalf("expected result: %+v, got: %+v", tc.wantResult, result)
+			}
+
+			var remain []string
+			entries, err := fsutil.ReadDir(csDataDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				remain = append(remain, entry.Name())
+			}
+			if !reflect.DeepEqual(tc.wantRemain, remain) {
+				t.Fatalf("expected remaining entries: %v, got: %v", tc.wantRemain, remain)
+			}
+
+			var wantActions []mount.FakeAction
+			for _, action := range tc.wantActions {
+				action.Target = path.Join(csDataDir, action.Target)
+				wantActions = append(wantActions, action)
+			}
+			if !reflect.DeepEqual(wantActions, mounter.GetLog()) {
+				t.Fatalf("expected actions: %v, got: %v", wantActions, mounter.GetLog())
+			}
+		})
+	}
+}
+
+func TestCreateVolumeContext(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	const confTemplatePath = "/etc/beegfs/beegfs-client.conf"
+	if err := fsutil.WriteFile(confTemplatePath, []byte(TestWriteClientFilesTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := pluginConfig{AllowedVolumeBeegfsClientConf: []string{"connMgmtdPortTCP"}}
+
+	tests := map[string]struct {
+		params   map[string]string
+		want     map[string]string
+		wantCode codes.Code
+	}{
+		"no overrides": {},
+		"allowed override": {
+			params: map[string]string{"beegfsClientConf/connMgmtdPortTCP": "9008"},
+			want:   map[string]string{"beegfsClientConf/connMgmtdPortTCP": "9008"},
+		},
+		"not allowed override": {
+			params:   map[string]string{"beegfsClientConf/connMgmtdPortUDP": "9008"},
+			wantCode: codes.InvalidArgument,
+		},
+		"transport": {
+			params: map[string]string{transportKey: "rdma", "beegfsClientConf/connMgmtdPortTCP": "9008"},
+			want:   map[string]string{transportKey: "rdma", "beegfsClientConf/connMgmtdPortTCP": "9008"},
+		},
+		"invalid transport": {
+			params:   map[string]string{transportKey: "roce"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cs := NewControllerServer("testnode", newPluginConfigStore(config), confTemplatePath, "/csDataDir")
+			cs.ctlExec = &fakeBeegfsCtlExecutor{}
+			params := map[string]string{sysMgmtdHostKey: "127.0.0.1", volDirBasePathKey: "/scratch"}
+			for k, v := range tc.params {
+				params[k] = v
+			}
+			resp, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "vol1",
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				}},
+				Parameters: params,
+			})
+			if tc.wantCode != codes.OK {
+				var grpcErr grpcError
+				if !errors.As(err, &grpcErr) || status.Code(grpcErr.GetStatusErr()) != tc.wantCode {
+					t.Fatalf("expected error with code %s, got: %v", tc.wantCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(tc.want, resp.GetVolume().GetVolumeContext()) {
+				t.Fatalf("expected volume context: %v, got: %v", tc.want, resp.GetVolume().GetVolumeContext())
+			}
+		})
+	}
+}
@@ -23,7 +23,7 @@ package beegfs
 
 import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/golang/glog"
+	"github.com/golang/protobuf/ptypes/wrappers"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -32,6 +32,7 @@ import (
 type identityServer struct {
 	name    string
 	version string
+	prober  *readinessProber // Probe always reports ready if prober is nil
 }
 
 func NewIdentityServer(name, version string) *identityServer {
@@ -42,7 +43,7 @@ func NewIdentityServer(name, version string) *identityServer {
 }
 
 func (ids *identityServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
-	glog.V(LogDebug).Infof("Using default GetPluginInfo")
+	newLogger(ctx).V(LogDebug).Info("Using default GetPluginInfo")
 
 	if ids.name == "" {
 		return nil, status.Error(codes.Unavailable, "Driver name not configured")
@@ -58,12 +59,26 @@ func (ids *identityServer) GetPluginInfo(ctx context.Context, req *csi.GetPlugin
 	}, nil
 }
 
+// Probe reports whether the driver's dependencies (e.g. beegfs-ctl and the BeeGFS client kernel module) are available.
+// Probe reports the cached results of periodic readiness checks, so it is cheap to call. It reports that the driver is
+// not ready until the checks have run once and while any check fails. CSI has no way to report why the driver is not
+// ready, so Probe logs the reason at the default verbosity instead. A failed check is not an error: the driver is
+// running and may become ready later.
 func (ids *identityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
-	return &csi.ProbeResponse{}, nil
+	if ids.prober == nil {
+		return &csi.ProbeResponse{}, nil
+	}
+	ready, err := ids.prober.status()
+	if err != nil {
+		newLogger(ctx).Info("Driver is not ready", "reason", err.Error())
+	} else if !ready {
+		newLogger(ctx).Info("Driver is not ready", "reason", "readiness checks have not run yet")
+	}
+	return &csi.ProbeResponse{Ready: &wrappers.BoolValue{Value: ready}}, nil
 }
 
 func (ids *identityServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
-	glog.V(LogDebug).Infof("Using default capabilities")
+	newLogger(ctx).V(LogDebug).Info("Using default capabilities")
 	return &csi.GetPluginCapabilitiesResponse{
 		Capabilities: []*csi.PluginCapability{
 			{
@@ -73,6 +88,13 @@ func (ids *identityServer) GetPluginCapabilities(ctx context.Context, req *csi.G
 					},
 				},
 			},
+			{
+				Type: &csi.PluginCapability_Service_{
+					Service: &csi.PluginCapability_Service{
+						Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+					},
+				},
+			},
 		},
 	}, nil
 }
@@ -0,0 +1,139 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"net"
+	"path"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// netInterface is a network interface connInterfacesSelectors are matched against.
+type netInterface struct {
+	name  string
+	up    bool
+	addrs []net.IP
+}
+
+// interfaceLister lists the network interfaces of the node the driver is running on.
+type interfaceLister interface {
+	listInterfaces() ([]netInterface, error)
+}
+
+// hostInterfaceLister lists the network interfaces in the driver's network namespace. The driver runs with
+// hostNetwork, so these are the host's interfaces.
+type hostInterfaceLister struct{}
+
+func (hostInterfaceLister) listInterfaces() ([]netInterface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing network interfaces")
+	}
+	var netInterfaces []netInterface
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing addresses of network interface %s", iface.Name)
+		}
+		netIface := netInterface{name: iface.Name, up: iface.Flags&net.FlagUp != 0}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				netIface.addrs = append(netIface.addrs, ipNet.IP)
+			}
+		}
+		netInterfaces = append(netInterfaces, netIface)
+	}
+	return netInterfaces, nil
+}
+
+// validateConnInterfacesSelector returns an error if selector is neither a CIDR (e.g. 10.10.0.0/16) nor a valid shell
+// pattern for interface names (e.g. ib* or ens2f[01], see path.Match).
+func validateConnInterfacesSelector(selector string) error {
+	if _, _, err := net.ParseCIDR(selector); err == nil {
+		return nil
+	}
+	if _, err := path.Match(selector, ""); err != nil || selector == "" {
+		return errors.Errorf("invalid connInterfacesSelector %s", selector)
+	}
+	return nil
+}
+
+// selectConnInterfaces returns the names of the interfaces in ifaces that are up and match any of selectors. A CIDR
+// selector matches an interface with an address in the CIDR and any other selector matches interface names. Selectors
+// are listed in order of preference, so interfaces matching earlier selectors come first. Interfaces matching the same
+// selector keep the order of ifaces.
+func selectConnInterfaces(selectors []string, ifaces []netInterface) []string {
+	var selected []string
+	isSelected := make(map[string]bool)
+	for _, selector := range selectors {
+		_, cidr, cidrErr := net.ParseCIDR(selector)
+		for _, iface := range ifaces {
+			if !iface.up || isSelected[iface.name] {
+				continue
+			}
+			matches := false
+			if cidrErr == nil {
+				for _, addr := range iface.addrs {
+					matches = matches || cidr.Contains(addr)
+				}
+			} else {
+				matches, _ = path.Match(selector, iface.name)
+			}
+			if matches {
+				selected = append(selected, iface.name)
+				isSelected[iface.name] = true
+			}
+		}
+	}
+	return selected
+}
+
+// resolveConnInterfaces sets the ConnInterfaces of every beegfsConfig in plConfig that has ConnInterfacesSelectors to
+// the matching interfaces lister lists and logs the outcome. Interfaces are only listed if a selector is configured.
+// If no interface matches, ConnInterfaces is left empty and the BeeGFS client uses all interfaces. plConfig may be a
+// copy of a configuration in a pluginConfigStore, so resolveConnInterfaces does not modify the slices it shares with
+// the original. Only the node service resolves connInterfacesSelectors (see nodeServer.loadConfig), as they describe
+// the node's interfaces.
+func (plConfig *pluginConfig) resolveConnInterfaces(lister interfaceLister) error {
+	// Each config is logged with the key-value pair that identifies it.
+	type selectorConfig struct {
+		config    *beegfsConfig
+		logValues []interface{}
+	}
+	var configs []selectorConfig
+	if len(plConfig.DefaultConfig.ConnInterfacesSelectors) != 0 {
+		configs = append(configs, selectorConfig{config: &plConfig.DefaultConfig,
+			logValues: []interface{}{"default_config", true}})
+	}
+	plConfig.FileSystemSpecificConfigs = append([]fileSystemSpecificConfig(nil), plConfig.FileSystemSpecificConfigs...)
+	for i := range plConfig.FileSystemSpecificConfigs {
+		fsConfig := &plConfig.FileSystemSpecificConfigs[i]
+		if len(fsConfig.Config.ConnInterfacesSelectors) != 0 {
+			configs = append(configs, selectorConfig{config: &fsConfig.Config,
+				logValues: []interface{}{"sys_mgmtd_host", fsConfig.SysMgmtdHost}})
+		}
+	}
+	if len(configs) == 0 {
+		return nil
+	}
+	ifaces, err := lister.listInterfaces()
+	if err != nil {
+		return errors.WithMessage(err, "failed to resolve connInterfacesSelectors")
+	}
+	for _, c := range configs {
+		c.config.ConnInterfaces = selectConnInterfaces(c.config.ConnInterfacesSelectors, ifaces)
+		logValues := append(c.logValues, "conn_interfaces_selectors", c.config.ConnInterfacesSelectors)
+		if len(c.config.ConnInterfaces) == 0 {
+			klog.InfoS("No network interface matches connInterfacesSelectors; the BeeGFS client will use all "+
+				"interfaces", logValues...)
+			continue
+		}
+		klog.InfoS("Selected connInterfaces", append(logValues, "conn_interfaces", c.config.ConnInterfaces)...)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// fakeInterfaceLister is an interfaceLister that returns fixed interfaces (or a fixed error) and counts calls.
+type fakeInterfaceLister struct {
+	ifaces []netInterface
+	err    error
+	calls  int
+}
+
+func (l *fakeInterfaceLister) listInterfaces() ([]netInterface, error) {
+	l.calls++
+	return l.ifaces, l.err
+}
+
+// testInterfaces are the interfaces of a node with an Ethernet adapter, an InfiniBand adapter with an address in
+// 10.1.0.0/16, a second InfiniBand adapter without an address, and an interface that is down.
+var testInterfaces = []netInterface{
+	{name: "lo", up: true, addrs: []net.IP{net.ParseIP("127.0.0.1")}},
+	{name: "eth0", up: true, addrs: []net.IP{net.ParseIP("10.2.0.5")}},
+	{name: "ib1", up: true},
+	{name: "ib0", up: true, addrs: []net.IP{net.ParseIP("10.1.0.7"), net.ParseIP("fe80::1")}},
+	{name: "ib2", up: false, addrs: []net.IP{net.ParseIP("10.1.0.8")}},
+}
+
+func TestSelectConnInterfaces(t *testing.T) {
+	tests := map[string]struct {
+		selectors []string
+		want      []string
+	}{
+		"cidr":      {selectors: []string{"10.1.0.0/16"}, want: []string{"ib0"}},
+		"ipv6 cidr": {selectors: []string{"fe80::/10"}, want: []string{"ib0"}},
+		"pattern":   {selectors: []string{"ib*"}, want: []string{"ib1", "ib0"}},
+		"earlier selectors first": {
+			selectors: []string{"10.1.0.0/16", "ib*", "eth*"},
+			want:      []string{"ib0", "ib1", "eth0"},
+		},
+		"no match":                  {selectors: []string{"ens*", "192.168.0.0/24"}},
+		"interfaces that are down":  {selectors: []string{"ib2"}},
+		"interface selected once":   {selectors: []string{"ib0", "10.0.0.0/8"}, want: []string{"ib0", "eth0"}},
+		"exact name and any device": {selectors: []string{"eth0", "*"}, want: []string{"eth0", "lo", "ib1", "ib0"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := selectConnInterfaces(tc.selectors, testInterfaces); !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected interfaces: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNodeServerLoadConfig(t *testing.T) {
+	rawConfig := `config:
+  connInterfacesSelectors:
+    - 10.1.0.0/16
+    - eth*
+fileSystemSpecificConfigs:
+  - sysMgmtdHost: 127.0.0.1
+    config:
+      connInterfaces:
+        - ib1
+  - sysMgmtdHost: 127.0.0.2
+    config:
+      connInterfacesSelectors:
+        - ens*
+nodeSpecificConfigs:
+  - nodeList:
+      - othernode
+    config:
+      connInterfaces:
+        - ib0
+`
+	parsed, err := parseConfig([]byte(rawConfig), "testnode", nil)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if parsed.DefaultConfig.ConnInterfaces != nil {
+		t.Fatalf("expected parseConfig not to resolve selectors, got: %v", parsed.DefaultConfig.ConnInterfaces)
+	}
+
+	lister := &fakeInterfaceLister{ifaces: testInterfaces}
+	store := newPluginConfigStore(parsed)
+	ns := NewNodeServer("testnode", store, "/etc/beegfs/beegfs-client.conf", "/csDataDir/ephemeral")
+	ns.ifaceLister = lister
+	got, err := ns.loadConfig()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if want := []string{"ib0", "eth0"}; !reflect.DeepEqual(want, got.DefaultConfig.ConnInterfaces) {
+		t.Fatalf("expected default connInterfaces: %v, got: %v", want, got.DefaultConfig.ConnInterfaces)
+	}
+	tests := map[string]struct {
+		sysMgmtdHost string
+		want         []string
+	}{
+		"selected by default config":             {sysMgmtdHost: "127.0.0.3", want: []string{"ib0", "eth0"}},
+		"file system interfaces replace":         {sysMgmtdHost: "127.0.0.1", want: []string{"ib1"}},
+		"file system selectors matching nothing": {sysMgmtdHost: "127.0.0.2"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			squashed := squashConfigForSysMgmtdHost(tc.sysMgmtdHost, got)
+			if !reflect.DeepEqual(tc.want, squashed.ConnInterfaces) {
+				t.Fatalf("expected connInterfaces: %v, got: %v", tc.want, squashed.ConnInterfaces)
+			}
+		})
+	}
+
+	// The stored configuration (which the controller service also uses) is not modified.
+	for _, fsConfig := range store.load().FileSystemSpecificConfigs {
+		if fsConfig.SysMgmtdHost == "127.0.0.2" && fsConfig.Config.ConnInterfaces != nil {
+			t.Fatalf("expected stored configuration not to be resolved, got: %v", fsConfig.Config.ConnInterfaces)
+		}
+	}
+
+	// Interfaces are only listed again when the configuration changes.
+	if _, err := ns.loadConfig(); err != nil || lister.calls != 1 {
+		t.Fatalf("expected cached configuration, got %d calls and error: %v", lister.calls, err)
+	}
+	store.store(parsed)
+	if _, err := ns.loadConfig(); err != nil || lister.calls != 2 {
+		t.Fatalf("expected reloaded configuration to be resolved, got %d calls and error: %v", lister.calls, err)
+	}
+
+	// Node specific connInterfaces replace selectors, so interfaces are not listed.
+	rawConfig = "config:\n  connInterfacesSelectors:\n    - ib*\n" +
+		"nodeSpecificConfigs:\n  - nodeList:\n      - testnode\n    config:\n      connInterfaces:\n        - ib0\n"
+	if parsed, err = parseConfig([]byte(rawConfig), "testnode", nil); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	store.store(parsed)
+	if got, err = ns.loadConfig(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !reflect.DeepEqual([]string{"ib0"}, got.DefaultConfig.ConnInterfaces) ||
+		got.DefaultConfig.ConnInterfacesSelectors != nil {
+		t.Fatalf("expected node specific connInterfaces to replace selectors, got: %+v", got.DefaultConfig)
+	}
+	if lister.calls != 2 {
+		t.Fatalf("expected interfaces not to be listed, got %d calls", lister.calls)
+	}
+
+	// A failure to list interfaces fails the load.
+	lister.err = errors.New("netlink failure")
+	if parsed, err = parseConfig([]byte("config:\n  connInterfacesSelectors:\n    - ib*\n"), "testnode", nil); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	store.store(parsed)
+	if _, err := ns.loadConfig(); err == nil {
+		t.Fatal("expected error, got none")
+	}
+}
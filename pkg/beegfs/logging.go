@@ -0,0 +1,234 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+// Valid values for the format parameter of InitLogging.
+const (
+	LogFormatText = "text" // klog's default format with key/value pairs appended to each message
+	LogFormatJSON = "json" // one JSON object per line
+)
+
+// requestIDMetadataKey is the gRPC response header logGRPC uses to return the request ID of an RPC to the caller.
+const requestIDMetadataKey = "x-request-id"
+
+// InitLogging configures the format of all log output (including klog output from dependencies). It must be called
+// before the driver runs.
+func InitLogging(format string) error {
+	switch format {
+	case "", LogFormatText:
+		return nil
+	case LogFormatJSON:
+		klog.SetLogger(newJSONLogger(os.Stderr))
+		return nil
+	default:
+		return errors.Errorf("invalid log format %s", format)
+	}
+}
+
+type requestIDKey struct{}
+
+// newRequestID returns a random identifier for an RPC.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand should never fail on Linux. Fall back to something that is still likely to be unique.
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+func withRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// requestIDFromContext returns the request ID logGRPC added to ctx or "" if there is none.
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// logger adds a fixed set of key/value pairs (e.g. the request ID of the RPC being handled) to every structured log
+// line it writes through klog. Its zero value writes log lines without additional key/value pairs.
+type logger struct {
+	keysAndValues []interface{}
+}
+
+// newLogger returns a logger that adds the request ID in ctx (if any) to every log line.
+func newLogger(ctx context.Context) logger {
+	if requestID := requestIDFromContext(ctx); requestID != "" {
+		return logger{keysAndValues: []interface{}{"request_id", requestID}}
+	}
+	return logger{}
+}
+
+// newVolumeLogger returns a logger that adds the request ID in ctx (if any) and identifying information about vol to
+// every log line.
+func newVolumeLogger(ctx context.Context, vol beegfsVolume) logger {
+	return newLogger(ctx).WithValues("volume_id", vol.volumeID, "sys_mgmtd_host", vol.sysMgmtdHost)
+}
+
+// WithValues returns a logger that adds keysAndValues to every log line in addition to l's key/value pairs.
+func (l logger) WithValues(keysAndValues ...interface{}) logger {
+	return logger{keysAndValues: l.join(keysAndValues)}
+}
+
+// Info writes an informational log line regardless of verbosity.
+func (l logger) Info(msg string, keysAndValues ...interface{}) {
+	klog.InfoSDepth(1, msg, l.join(keysAndValues)...)
+}
+
+// Error writes an error log line.
+func (l logger) Error(err error, msg string, keysAndValues ...interface{}) {
+	klog.ErrorSDepth(1, err, msg, l.join(keysAndValues)...)
+}
+
+// V returns a verboseLogger that only writes log lines if the configured verbosity is at least level.
+func (l logger) V(level klog.Level) verboseLogger {
+	return verboseLogger{logger: l, enabled: klog.V(level).Enabled()}
+}
+
+func (l logger) join(keysAndValues []interface{}) []interface{} {
+	joined := make([]interface{}, 0, len(l.keysAndValues)+len(keysAndValues))
+	return append(append(joined, l.keysAndValues...), keysAndValues...)
+}
+
+type verboseLogger struct {
+	logger  logger
+	enabled bool
+}
+
+// Info writes an informational log line if the verbosity verboseLogger was created with is enabled.
+func (v verboseLogger) Info(msg string, keysAndValues ...interface{}) {
+	if v.enabled {
+		klog.InfoSDepth(1, msg, v.logger.join(keysAndValues)...)
+	}
+}
+
+// jsonLogger is a logr.Logger that writes one JSON object per log line. klog filters log lines by verbosity before
+// passing them to jsonLogger, so jsonLogger writes everything it receives.
+type jsonLogger struct {
+	mu            *sync.Mutex
+	w             io.Writer
+	name          string
+	keysAndValues []interface{}
+	depth         int
+}
+
+var _ logr.CallDepthLogger = jsonLogger{}
+
+func newJSONLogger(w io.Writer) logr.Logger {
+	return jsonLogger{mu: new(sync.Mutex), w: w}
+}
+
+func (l jsonLogger) Enabled() bool { return true }
+
+func (l jsonLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.write("info", msg, nil, keysAndValues)
+}
+
+func (l jsonLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.write("error", msg, err, keysAndValues)
+}
+
+func (l jsonLogger) V(level int) logr.Logger { return l }
+
+func (l jsonLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	l.keysAndValues = append(append([]interface{}{}, l.keysAndValues...), keysAndValues...)
+	return l
+}
+
+func (l jsonLogger) WithName(name string) logr.Logger {
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+	l.name = name
+	return l
+}
+
+func (l jsonLogger) WithCallDepth(depth int) logr.Logger {
+	l.depth += depth
+	return l
+}
+
+// write must be called directly from Info or Error so that the caller can be determined correctly.
+func (l jsonLogger) write(level, msg string, err error, keysAndValues []interface{}) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"ts":`)
+	writeJSONValue(&buf, time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSONValue(&buf, level)
+	if _, file, line, ok := runtime.Caller(l.depth + 2); ok {
+		buf.WriteString(`,"caller":`)
+		writeJSONValue(&buf, fmt.Sprintf("%s:%d", path.Base(file), line))
+	}
+	if l.name != "" {
+		buf.WriteString(`,"logger":`)
+		writeJSONValue(&buf, l.name)
+	}
+	buf.WriteString(`,"msg":`)
+	writeJSONValue(&buf, msg)
+	if err != nil {
+		buf.WriteString(`,"err":`)
+		writeJSONValue(&buf, err)
+	}
+	for _, kvs := range [][]interface{}{l.keysAndValues, keysAndValues} {
+		for i := 0; i < len(kvs); i += 2 {
+			key, ok := kvs[i].(string)
+			if !ok {
+				key = fmt.Sprint(kvs[i])
+			}
+			var value interface{} = "(MISSING)"
+			if i+1 < len(kvs) {
+				value = kvs[i+1]
+			}
+			buf.WriteByte(',')
+			writeJSONValue(&buf, key)
+			buf.WriteByte(':')
+			writeJSONValue(&buf, value)
+		}
+	}
+	buf.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(buf.Bytes())
+}
+
+// writeJSONValue writes value to buf as JSON. Errors and fmt.Stringers are written as strings. Values that cannot be
+// marshalled are written as strings using their default format.
+func writeJSONValue(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case fmt.Stringer:
+		value = v.String()
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprintf("%+v", value))
+	}
+	buf.Write(b)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"k8s.io/klog/v2"
+)
+
+// parseJSONLines returns one map per line of JSON output written by a jsonLogger.
+func parseJSONLines(t *testing.T, output string) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("expected valid JSON, got: %s: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestJSONLogger(t *testing.T) {
+	tests := map[string]struct {
+		log  func(buf *bytes.Buffer)
+		want map[string]interface{}
+	}{
+		"info": {
+			log: func(buf *bytes.Buffer) {
+				newJSONLogger(buf).WithValues("request_id", "0123456789abcdef").Info("Mounting volume",
+					"volume_id", "beegfs://127.0.0.1/scratch/vol1", "duration", 1500*time.Millisecond)
+			},
+			want: map[string]interface{}{
+				"level":      "info",
+				"msg":        "Mounting volume",
+				"request_id": "0123456789abcdef",
+				"volume_id":  "beegfs://127.0.0.1/scratch/vol1",
+				"duration":   "1.5s",
+			},
+		},
+		"error": {
+			log: func(buf *bytes.Buffer) {
+				newJSONLogger(buf).WithName("beegfs").Error(errors.New("mount failed"), "GRPC error",
+					"method", "/csi.v1.Node/NodeStageVolume", "missing")
+			},
+			want: map[string]interface{}{
+				"level":   "error",
+				"logger":  "beegfs",
+				"msg":     "GRPC error",
+				"err":     "mount failed",
+				"method":  "/csi.v1.Node/NodeStageVolume",
+				"missing": "(MISSING)",
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tc.log(&buf)
+			lines := parseJSONLines(t, buf.String())
+			if len(lines) != 1 {
+				t.Fatalf("expected 1 line, got: %d", len(lines))
+			}
+			got := lines[0]
+			if _, ok := got["ts"]; !ok {
+				t.Fatalf("expected ts field, got: %v", got)
+			}
+			if caller, _ := got["caller"].(string); !strings.HasPrefix(caller, "logging_test.go:") {
+				t.Fatalf("expected caller in logging_test.go, got: %v", got["caller"])
+			}
+			delete(got, "ts")
+			delete(got, "caller")
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestInitLogging(t *testing.T) {
+	if err := InitLogging(LogFormatText); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := InitLogging("xml"); err == nil {
+		t.Fatal("expected error, got none")
+	}
+}
+
+func TestLogGRPCRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	klog.SetLogger(newJSONLogger(&buf))
+	defer klog.SetLogger(nil)
+
+	var requestID string
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/DeleteVolume"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		requestID = requestIDFromContext(ctx)
+		newLogger(ctx).Info("Handling request")
+		return nil, newGrpcError(codes.Internal, "delete failed")
+	}
+	if _, err := logGRPC(context.Background(), nil, info, handler); err == nil {
+		t.Fatal("expected error, got none")
+	}
+
+	if requestID == "" {
+		t.Fatal("expected handler context to contain a request ID")
+	}
+	lines := parseJSONLines(t, buf.String())
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got: %d: %s", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		if line["request_id"] != requestID {
+			t.Fatalf("expected request_id %s, got: %v", requestID, line)
+		}
+	}
+	if caller, _ := lines[0]["caller"].(string); !strings.HasPrefix(caller, "logging_test.go:") {
+		t.Fatalf("expected caller in logging_test.go, got: %v", lines[0]["caller"])
+	}
+	if lines[1]["method"] != info.FullMethod || lines[1]["level"] != "error" {
+		t.Fatalf("expected error line for %s, got: %v", info.FullMethod, lines[1])
+	}
+}
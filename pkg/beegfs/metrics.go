@@ -0,0 +1,209 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/ini.v1"
+	"k8s.io/klog/v2"
+)
+
+const metricsNamespace = "beegfs_csi"
+
+var (
+	// metricsRegistry contains all metrics exported by the driver. A dedicated registry (instead of the Prometheus
+	// default registry) ensures the driver only exports metrics it explicitly registers.
+	metricsRegistry = prometheus.NewRegistry()
+
+	rpcRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rpc_requests_total",
+		Help:      "Number of CSI RPCs handled by method and gRPC code.",
+	}, []string{"method", "code"})
+	rpcDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Time taken to handle CSI RPCs by method and gRPC code.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14), // 5ms to ~41s
+	}, []string{"method", "code"})
+	beegfsCtlInvocationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "beegfs_ctl_invocations_total",
+		Help:      "Number of beegfs-ctl invocations by subcommand and outcome.",
+	}, []string{"subcommand", "outcome"})
+	beegfsCtlDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "beegfs_ctl_duration_seconds",
+		Help:      "Time taken by beegfs-ctl invocations by subcommand and outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14), // 5ms to ~41s
+	}, []string{"subcommand", "outcome"})
+	udpPortAllocationFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "udp_port_allocation_failures_total",
+		Help:      "Number of failures to allocate a connClientPortUDP for a BeeGFS mount.",
+	})
+	csDataDirSweepItemsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cs_data_dir_sweep_items_total",
+		Help:      "Number of stale mounts and directories the startup sweep of csDataDir handled by action.",
+	}, []string{"action", "dry_run"})
+	configGeneration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_generation",
+		Help:      "Generation of the plugin configuration in use. It starts at 1 and increases with each reload.",
+	})
+	configReloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_reloads_total",
+		Help:      "Number of attempts to reload a changed configuration file by outcome.",
+	}, []string{"outcome"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGoCollector(),
+		rpcRequestsTotal,
+		rpcDurationSeconds,
+		beegfsCtlInvocationsTotal,
+		beegfsCtlDurationSeconds,
+		udpPortAllocationFailuresTotal,
+		csDataDirSweepItemsTotal,
+		configGeneration,
+		configReloadsTotal,
+		newMountCollector(),
+	)
+}
+
+// ServeMetrics serves the driver's Prometheus metrics at /metrics on address (e.g. ":9090"). It only returns if the
+// HTTP server fails.
+func ServeMetrics(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	klog.InfoS("Serving metrics", "address", address)
+	return errors.WithStack(http.ListenAndServe(address, mux))
+}
+
+// observeRPC records the outcome of a CSI RPC.
+func observeRPC(fullMethod, code string, start time.Time) {
+	method := path.Base(fullMethod) // e.g. /csi.v1.Node/NodeStageVolume -> NodeStageVolume
+	rpcRequestsTotal.WithLabelValues(method, code).Inc()
+	rpcDurationSeconds.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+}
+
+// observeBeegfsCtl records the outcome of a beegfs-ctl invocation with arguments args.
+func observeBeegfsCtl(args []string, err error, start time.Time) {
+	outcome := "success"
+	switch {
+	case errors.As(err, &ctlNotExistError{}):
+		outcome = "not_exist"
+	case errors.As(err, &ctlExistError{}):
+		outcome = "exist"
+	case err != nil:
+		outcome = "error"
+	}
+	subcommand := beegfsCtlSubcommand(args)
+	beegfsCtlInvocationsTotal.WithLabelValues(subcommand, outcome).Inc()
+	beegfsCtlDurationSeconds.WithLabelValues(subcommand, outcome).Observe(time.Since(start).Seconds())
+}
+
+// beegfsCtlSubcommand returns the beegfs-ctl mode (e.g. createdir for --createdir) from args or "unknown". Only
+// modes the driver uses are recognized so that the label has a bounded set of values.
+func beegfsCtlSubcommand(args []string) string {
+	for _, arg := range args {
+		switch arg {
+		case "--createdir", "--getentryinfo", "--setpattern":
+			return strings.TrimPrefix(arg, "--")
+		}
+	}
+	return "unknown"
+}
+
+// observeDataDirSweep records what a startup sweep of csDataDir handled.
+func observeDataDirSweep(result dataDirSweepResult, dryRun bool) {
+	dryRunLabel := "false"
+	if dryRun {
+		dryRunLabel = "true"
+	}
+	csDataDirSweepItemsTotal.WithLabelValues("unmounted", dryRunLabel).Add(float64(result.unmounted))
+	csDataDirSweepItemsTotal.WithLabelValues("removed", dryRunLabel).Add(float64(result.removed))
+	csDataDirSweepItemsTotal.WithLabelValues("failed", dryRunLabel).Add(float64(result.failed))
+}
+
+// mountCollector reports the number of BeeGFS file systems the driver has mounted per sysMgmtdHost. It inspects the
+// mount table each time it is collected instead of tracking mounts as they are made so that its count stays accurate
+// across driver restarts.
+type mountCollector struct {
+	desc *prometheus.Desc
+}
+
+func newMountCollector() *mountCollector {
+	return &mountCollector{
+		desc: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "mounts"),
+			"Number of BeeGFS file systems mounted by the driver by sysMgmtdHost.", []string{"sys_mgmtd_host"}, nil),
+	}
+}
+
+func (c *mountCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *mountCollector) Collect(ch chan<- prometheus.Metric) {
+	infos, err := readMountInfo()
+	if err != nil {
+		klog.ErrorS(err, "Failed to collect BeeGFS mount metrics")
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	for sysMgmtdHost, count := range countDriverMounts(infos) {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), sysMgmtdHost)
+	}
+}
+
+// countDriverMounts returns the number of BeeGFS file systems mounted by the driver per sysMgmtdHost. The driver
+// always mounts a BeeGFS file system from its root at mountDirPath/mount with cfgFile=mountDirPath/beegfs-client.conf.
+// Bind mounts and duplicates under hostRootPath do not match this layout and are not counted.
+func countDriverMounts(infos []mountInfo) map[string]int {
+	counts := make(map[string]int)
+	for _, info := range infos {
+		if info.fsType != "beegfs" || info.source != "beegfs_nodev" || info.root != "/" {
+			continue
+		}
+		clientConfPath := cfgFileFromMountOpts(info.superOptions)
+		if clientConfPath == "" {
+			clientConfPath = cfgFileFromMountOpts(info.mountOptions)
+		}
+		if clientConfPath == "" || info.mountPoint != path.Join(path.Dir(clientConfPath), "mount") {
+			continue
+		}
+		counts[sysMgmtdHostFromClientConf(clientConfPath)]++
+	}
+	return counts
+}
+
+// sysMgmtdHostFromClientConf returns the sysMgmtdHost from the beegfs-client.conf file at clientConfPath or
+// "unknown" if it cannot be read.
+func sysMgmtdHostFromClientConf(clientConfPath string) string {
+	clientConfBytes, err := fsutil.ReadFile(clientConfPath)
+	if err != nil {
+		return "unknown"
+	}
+	clientConfINI, err := ini.Load(clientConfBytes)
+	if err != nil {
+		return "unknown"
+	}
+	if sysMgmtdHost := clientConfINI.Section("").Key("sysMgmtdHost").String(); sysMgmtdHost != "" {
+		return sysMgmtdHost
+	}
+	return "unknown"
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/afero"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestBeegfsCtlSubcommand(t *testing.T) {
+	tests := map[string]struct {
+		args []string
+		want string
+	}{
+		"createdir": {
+			args: []string{"--cfgFile=/path/beegfs-client.conf", "--unmounted", "--createdir", "--access=0777", "/dir"},
+			want: "createdir",
+		},
+		"getentryinfo": {
+			args: []string{"--cfgFile=/path/beegfs-client.conf", "--unmounted", "--getentryinfo", "/dir"},
+			want: "getentryinfo",
+		},
+		"setpattern": {
+			args: []string{"--cfgFile=/path/beegfs-client.conf", "--unmounted", "--setpattern", "--numtargets=4", "/dir"},
+			want: "setpattern",
+		},
+		"unknown": {
+			args: []string{"--cfgFile=/path/beegfs-client.conf", "--listnodes"},
+			want: "unknown",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := beegfsCtlSubcommand(tc.args); got != tc.want {
+				t.Fatalf("expected: %s, got: %s", tc.want, got)
+			}
+		})
+	}
+}
+
+// TestCountDriverMounts uses the mountinfo files described in TestFindBindMounts.
+func TestCountDriverMounts(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	// pvc-1 and pvc-10 are on the same file system. pvc-2 has no beegfs-client.conf file.
+	for conf, sysMgmtdHost := range map[string]string{
+		"/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-1/globalmount/beegfs-client.conf":  "10.113.72.217",
+		"/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-10/globalmount/beegfs-client.conf": "10.113.72.217",
+	} {
+		if err := fsutil.WriteFile(conf, []byte("sysMgmtdHost = "+sysMgmtdHost+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]struct {
+		mountInfoFile string
+		want          map[string]int
+	}{
+		"host": {
+			mountInfoFile: "testdata/mountinfo-host.txt",
+			want:          map[string]int{"10.113.72.217": 2, "unknown": 1},
+		},
+		"container": {
+			mountInfoFile: "testdata/mountinfo-container.txt",
+			want:          map[string]int{"10.113.72.217": 2, "unknown": 1},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			file, err := os.Open(tc.mountInfoFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			infos, err := parseMountInfo(file)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if got := countDriverMounts(infos); !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestLogGRPCMetrics(t *testing.T) {
+	fs = afero.NewOsFs() // Gathering metrics reads the real mount table.
+	fsutil = afero.Afero{Fs: fs}
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeGetVolumeStats"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, newGrpcError(codes.Unimplemented, "")
+	}
+	getCount := func() float64 {
+		families, err := metricsRegistry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, family := range families {
+			if family.GetName() != "beegfs_csi_rpc_requests_total" {
+				continue
+			}
+			for _, m := range family.GetMetric() {
+				labels := make(map[string]string)
+				for _, label := range m.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				if labels["method"] == "NodeGetVolumeStats" && labels["code"] == codes.Unimplemented.String() {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+		return 0
+	}
+
+	before := getCount()
+	if _, err := logGRPC(context.Background(), nil, info, handler); err == nil {
+		t.Fatal("expected error, got none")
+	}
+	if after := getCount(); after != before+1 {
+		t.Fatalf("expected counter to increase from %v to %v, got: %v", before, before+1, after)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// hostRootPath is the directory at which the driver's containers mount the host's root file system.
+const hostRootPath = "/host"
+
+// mountInfoPath is a variable so that tests can supply their own mountinfo file.
+var mountInfoPath = "/proc/self/mountinfo"
+
+// mountInfo represents a single line in /proc/<pid>/mountinfo (see proc(5)). For example:
+//
+//	1285 22 0:56 / /mnt/beegfs rw,relatime shared:710 - beegfs beegfs_nodev rw,cfgFile=/etc/beegfs/beegfs-client.conf
+type mountInfo struct {
+	mountID        int      // unique ID for the mount (may be reused after unmount)
+	parentID       int      // ID of the parent mount
+	majorMinor     string   // st_dev of files in the file system (e.g. 0:56); shared by all mounts of a file system
+	root           string   // path of the directory in the file system that forms the root of this mount
+	mountPoint     string   // path of the mount point relative to the process's root directory
+	mountOptions   []string // per-mount options
+	optionalFields []string // e.g. shared:710 or master:1
+	fsType         string   // e.g. beegfs
+	source         string   // e.g. beegfs_nodev
+	superOptions   []string // per-superblock options (BeeGFS reports cfgFile here)
+}
+
+// readMountInfo reads and parses the mountinfo file of the current process.
+func readMountInfo() ([]mountInfo, error) {
+	file, err := fs.Open(mountInfoPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer file.Close()
+	infos, err := parseMountInfo(file)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to parse %s", mountInfoPath)
+	}
+	return infos, nil
+}
+
+// parseMountInfo parses the contents of a mountinfo file.
+func parseMountInfo(r io.Reader) ([]mountInfo, error) {
+	var infos []mountInfo
+	scanner := bufio.NewScanner(r)
+	// Lines describing overlay file systems can be longer than the default maximum token size.
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		info, err := parseMountInfoLine(line)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return infos, nil
+}
+
+// parseMountInfoLine parses a single line of a mountinfo file.
+func parseMountInfoLine(line string) (info mountInfo, err error) {
+	fields := strings.Fields(line)
+	// There are six fields before the optional fields and three fields after the separator.
+	if len(fields) < 10 {
+		return mountInfo{}, errors.Errorf("too few fields in mountinfo line %q", line)
+	}
+	if info.mountID, err = strconv.Atoi(fields[0]); err != nil {
+		return mountInfo{}, errors.Errorf("invalid mount ID in mountinfo line %q", line)
+	}
+	if info.parentID, err = strconv.Atoi(fields[1]); err != nil {
+		return mountInfo{}, errors.Errorf("invalid parent ID in mountinfo line %q", line)
+	}
+	if !strings.Contains(fields[2], ":") {
+		return mountInfo{}, errors.Errorf("invalid major:minor in mountinfo line %q", line)
+	}
+	info.majorMinor = fields[2]
+	info.root = unescapeMountInfoField(fields[3])
+	info.mountPoint = unescapeMountInfoField(fields[4])
+	info.mountOptions = splitMountInfoOptions(fields[5])
+
+	// The optional fields are terminated by a single hyphen.
+	i := 6
+	for ; i < len(fields) && fields[i] != "-"; i++ {
+		info.optionalFields = append(info.optionalFields, fields[i])
+	}
+	if len(fields)-i != 4 {
+		return mountInfo{}, errors.Errorf("invalid fields after separator in mountinfo line %q", line)
+	}
+	info.fsType = unescapeMountInfoField(fields[i+1])
+	info.source = unescapeMountInfoField(fields[i+2])
+	info.superOptions = splitMountInfoOptions(fields[i+3])
+	return info, nil
+}
+
+// splitMountInfoOptions splits a comma separated mountinfo options field into unescaped options.
+func splitMountInfoOptions(field string) []string {
+	opts := strings.Split(field, ",")
+	for i := range opts {
+		opts[i] = unescapeMountInfoField(opts[i])
+	}
+	return opts
+}
+
+// unescapeMountInfoField replaces the octal escape sequences the kernel uses for space, tab, newline, and backslash
+// (e.g. \040) with the characters they represent.
+func unescapeMountInfoField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+4 <= len(field) {
+			if c, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
+
+// isBeegfsMountOf returns true if info describes a mount of the BeeGFS file system mounted with the configuration file
+// at clientConfPath.
+func (info mountInfo) isBeegfsMountOf(clientConfPath string) bool {
+	if info.fsType != "beegfs" || info.source != "beegfs_nodev" {
+		return false
+	}
+	return cfgFileFromMountOpts(info.superOptions) == clientConfPath ||
+		cfgFileFromMountOpts(info.mountOptions) == clientConfPath
+}
+
+// findBindMounts returns the mount points of all bind mounts of the BeeGFS file system mounted at mountPath with the
+// configuration file at clientConfPath. It returns nothing if no such file system is mounted at mountPath.
+//
+// The file system mounted at mountPath is identified by its mount point, its root (a BeeGFS file system is always
+// mounted from its root), its source, and its cfgFile option. Any other mount with the same major:minor (i.e. of the
+// same file system) is a bind mount, with one exception: when the host's root file system is mounted at hostRootPath,
+// the file system mounted at mountPath also appears at hostRootPath+mountPath. This duplicate is not a bind mount.
+func findBindMounts(infos []mountInfo, mountPath, clientConfPath string) (bindMounts []string) {
+	var fsMount *mountInfo
+	hostMountPath := ""
+	for i, info := range infos {
+		if info.mountPoint == mountPath && info.root == "/" && info.isBeegfsMountOf(clientConfPath) {
+			fsMount = &infos[i]
+		}
+		if info.mountPoint == hostRootPath && info.root == "/" {
+			hostMountPath = path.Join(hostRootPath, mountPath)
+		}
+	}
+	if fsMount == nil {
+		return nil
+	}
+
+	for _, info := range infos {
+		if info.mountID == fsMount.mountID || info.majorMinor != fsMount.majorMinor {
+			continue
+		}
+		if info.mountPoint == hostMountPath && info.root == "/" {
+			continue
+		}
+		bindMounts = append(bindMounts, info.mountPoint)
+	}
+	return bindMounts
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMountInfo(t *testing.T) {
+	tests := map[string]struct {
+		mountInfo string
+		want      []mountInfo
+		wantErr   bool
+	}{
+		"beegfs mount": {
+			mountInfo: "1285 22 0:56 / /mnt/beegfs rw,relatime shared:710 - beegfs beegfs_nodev " +
+				"rw,cfgFile=/etc/beegfs/beegfs-client.conf,_netdev\n",
+			want: []mountInfo{{
+				mountID:        1285,
+				parentID:       22,
+				majorMinor:     "0:56",
+				root:           "/",
+				mountPoint:     "/mnt/beegfs",
+				mountOptions:   []string{"rw", "relatime"},
+				optionalFields: []string{"shared:710"},
+				fsType:         "beegfs",
+				source:         "beegfs_nodev",
+				superOptions:   []string{"rw", "cfgFile=/etc/beegfs/beegfs-client.conf", "_netdev"},
+			}},
+		},
+		"no optional fields": {
+			mountInfo: "2346 2345 0:252 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw\n",
+			want: []mountInfo{{
+				mountID:      2346,
+				parentID:     2345,
+				majorMinor:   "0:252",
+				root:         "/",
+				mountPoint:   "/proc",
+				mountOptions: []string{"rw", "nosuid", "nodev", "noexec", "relatime"},
+				fsType:       "proc",
+				source:       "proc",
+				superOptions: []string{"rw"},
+			}},
+		},
+		"multiple optional fields and blank lines": {
+			mountInfo: "\n2400 2345 253:0 / /host rw master:1 propagate_from:1 - xfs /dev/sda1 rw\n\n",
+			want: []mountInfo{{
+				mountID:        2400,
+				parentID:       2345,
+				majorMinor:     "253:0",
+				root:           "/",
+				mountPoint:     "/host",
+				mountOptions:   []string{"rw"},
+				optionalFields: []string{"master:1", "propagate_from:1"},
+				fsType:         "xfs",
+				source:         "/dev/sda1",
+				superOptions:   []string{"rw"},
+			}},
+		},
+		"escaped characters": {
+			mountInfo: `1301 22 0:56 /dir\040with\011tabs\134 /mnt/my\040beegfs rw - beegfs beegfs_nodev ` +
+				`rw,cfgFile=/path\040to/beegfs-client.conf`,
+			want: []mountInfo{{
+				mountID:      1301,
+				parentID:     22,
+				majorMinor:   "0:56",
+				root:         "/dir with\ttabs\\",
+				mountPoint:   "/mnt/my beegfs",
+				mountOptions: []string{"rw"},
+				fsType:       "beegfs",
+				source:       "beegfs_nodev",
+				superOptions: []string{"rw", "cfgFile=/path to/beegfs-client.conf"},
+			}},
+		},
+		"too few fields": {
+			mountInfo: "1285 22 0:56 / /mnt/beegfs rw - beegfs beegfs_nodev",
+			wantErr:   true,
+		},
+		"missing separator": {
+			mountInfo: "1285 22 0:56 / /mnt/beegfs rw shared:710 beegfs beegfs_nodev rw",
+			wantErr:   true,
+		},
+		"invalid mount ID": {
+			mountInfo: "abc 22 0:56 / /mnt/beegfs rw - beegfs beegfs_nodev rw",
+			wantErr:   true,
+		},
+		"invalid major:minor": {
+			mountInfo: "1285 22 056 / /mnt/beegfs rw - beegfs beegfs_nodev rw",
+			wantErr:   true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseMountInfo(strings.NewReader(tc.mountInfo))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got: %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected: %+v, got: %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+// TestFindBindMounts makes use of mountinfo files in the testdata directory. They are hand-written (not captured) and
+// follow the layout of /proc/self/mountinfo on a Kubernetes node (mountinfo-host.txt) and in the node service container
+// on the same node (mountinfo-container.txt), which has the host's root file system mounted at /host. Overlay IDs and
+// the Pod UID are placeholders. Both show a volume (pvc-1) staged and published to a Pod, a volume
+// (pvc-10) whose path has pvc-1's path as a prefix, and a volume (pvc-2) that is staged but not published.
+func TestFindBindMounts(t *testing.T) {
+	const (
+		pvc1Dir  = "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-1/globalmount"
+		pvc2Dir  = "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-2/globalmount"
+		pvc3Dir  = "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-3/globalmount"
+		pvc1Pod  = "/var/lib/kubelet/pods/5f6a0b8c-1d2e-4f3a-9b8c-7d6e5f4a3b2c/volumes/kubernetes.io~csi/pvc-1/mount"
+		pvc10Dir = "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-10/globalmount"
+		pvc10Pod = "/var/lib/kubelet/pods/0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d/volumes/kubernetes.io~csi/pvc-10/mount"
+	)
+	tests := map[string]struct {
+		mountInfoFile  string
+		mountDirPath   string
+		clientConfPath string // defaults to mountDirPath/beegfs-client.conf
+		want           []string
+	}{
+		"host published volume": {
+			mountInfoFile: "testdata/mountinfo-host.txt",
+			mountDirPath:  pvc1Dir,
+			want:          []string{pvc1Pod},
+		},
+		"host staged volume": {
+			mountInfoFile: "testdata/mountinfo-host.txt",
+			mountDirPath:  pvc2Dir,
+		},
+		"host volume with prefix of published volume": {
+			mountInfoFile: "testdata/mountinfo-host.txt",
+			mountDirPath:  pvc10Dir,
+			want:          []string{pvc10Pod},
+		},
+		"host unmounted volume": {
+			mountInfoFile: "testdata/mountinfo-host.txt",
+			mountDirPath:  pvc3Dir,
+		},
+		"host different cfgFile": {
+			mountInfoFile:  "testdata/mountinfo-host.txt",
+			mountDirPath:   pvc1Dir,
+			clientConfPath: pvc2Dir + "/beegfs-client.conf",
+		},
+		"container published volume": {
+			mountInfoFile: "testdata/mountinfo-container.txt",
+			mountDirPath:  pvc1Dir,
+			want:          []string{"/host" + pvc1Pod, pvc1Pod},
+		},
+		"container volume with prefix of published volume": {
+			mountInfoFile: "testdata/mountinfo-container.txt",
+			mountDirPath:  pvc10Dir,
+			want:          []string{"/host" + pvc10Pod, pvc10Pod},
+		},
+		"container staged volume": {
+			mountInfoFile: "testdata/mountinfo-container.txt",
+			mountDirPath:  pvc2Dir,
+		},
+		"container unmounted volume": {
+			mountInfoFile: "testdata/mountinfo-container.txt",
+			mountDirPath:  pvc3Dir,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			file, err := os.Open(tc.mountInfoFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			infos, err := parseMountInfo(file)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			clientConfPath := tc.clientConfPath
+			if clientConfPath == "" {
+				clientConfPath = tc.mountDirPath + "/beegfs-client.conf"
+			}
+			got := findBindMounts(infos, tc.mountDirPath+"/mount", clientConfPath)
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -22,14 +22,19 @@ Licensed under the Apache License, Version 2.0.
 package beegfs
 
 import (
+	"encoding/json"
 	"os"
+	"path"
+	"strings"
+	"sync"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/golang/glog"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
 	"k8s.io/utils/mount"
 )
 
@@ -39,30 +44,73 @@ var (
 	}
 )
 
+// The node service writes the beegfs:// URL of an ephemeral volume's BeeGFS directory to a file with this name in the
+// volume's mountDirPath so NodeUnpublishVolume can find and delete the directory.
+const ephemeralVolumeIDFileName = "volumeID"
+
+// The node service writes an ephemeral volume's volume context (as JSON) to a file with this name in the volume's
+// mountDirPath so NodeUnpublishVolume can render the same client configuration files NodePublishVolume did (e.g. with
+// the same beegfsClientConf overrides and transport) if it has to mount the file system again.
+const ephemeralVolumeContextFileName = "volumeContext"
+
 type nodeServer struct {
+	ctlExec                beegfsCtlExecutorInterface
 	nodeID                 string
-	pluginConfig           pluginConfig
+	configStore            *pluginConfigStore
 	clientConfTemplatePath string
+	ephemeralDataDir       string // directory node service uses to create BeeGFS config files and mount file systems for ephemeral volumes
+	udpPorts               *udpPortAllocator
 	mounter                mount.Interface
+	ifaceLister            interfaceLister
+
+	resolvedConfigMutex sync.Mutex
+	resolvedConfig      *loadedPluginConfig // the last configuration loadConfig resolved, nil until it is first called
 }
 
-func NewNodeServer(nodeId string, pluginConfig pluginConfig, clientConfTemplatePath string) *nodeServer {
+func NewNodeServer(nodeId string, configStore *pluginConfigStore, clientConfTemplatePath, ephemeralDataDir string) *nodeServer {
 	return &nodeServer{
+		ctlExec:                &beegfsCtlExecutor{},
 		nodeID:                 nodeId,
-		pluginConfig:           pluginConfig,
+		configStore:            configStore,
 		clientConfTemplatePath: clientConfTemplatePath,
+		ephemeralDataDir:       ephemeralDataDir,
+		udpPorts:               newUDPPortAllocator(path.Dir(ephemeralDataDir)), // ephemeralDataDir is in csDataDir
 		mounter:                nil,
+		ifaceLister:            hostInterfaceLister{},
 	}
 }
 
+// loadConfig returns the current configuration with connInterfacesSelectors resolved against the node's network
+// interfaces. The result is cached until the configuration changes, so interfaces are listed and the selected
+// interfaces are logged once per configuration. RPCs that render client configuration files must use loadConfig
+// instead of loading from configStore directly.
+func (ns *nodeServer) loadConfig() (pluginConfig, error) {
+	config, generation := ns.configStore.loadWithGeneration()
+	ns.resolvedConfigMutex.Lock()
+	defer ns.resolvedConfigMutex.Unlock()
+	if ns.resolvedConfig != nil && ns.resolvedConfig.generation == generation {
+		return ns.resolvedConfig.config, nil
+	}
+	if err := config.resolveConnInterfaces(ns.ifaceLister); err != nil {
+		return pluginConfig{}, err
+	}
+	ns.resolvedConfig = &loadedPluginConfig{config: config, generation: generation}
+	return config, nil
+}
+
+// NodePublishVolume bind mounts a BeeGFS directory that was previously staged by NodeStageVolume onto the target path.
+// If the request is for an ephemeral volume, there is no staged file system. Instead, NodePublishVolume creates a
+// uniquely named BeeGFS directory using parameters from the volume context and mounts the file system itself before
+// bind mounting the directory.
 func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
 	// Check arguments.
 	volumeID := req.GetVolumeId()
 	if len(volumeID) == 0 {
 		return nil, status.Errorf(codes.InvalidArgument, "Volume ID not provided")
 	}
+	ephemeral := req.GetVolumeContext()[ephemeralKey] == "true"
 	stagingTargetPath := req.GetStagingTargetPath()
-	if len(stagingTargetPath) == 0 {
+	if len(stagingTargetPath) == 0 && !ephemeral {
 		return nil, status.Error(codes.InvalidArgument, "Staging target path not provided")
 	}
 	targetPath := req.GetTargetPath()
@@ -77,10 +125,34 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		return nil, status.Errorf(codes.InvalidArgument, "Volume capability not supported: %s", reason)
 	}
 	readOnly := req.GetReadonly()
-
-	vol, err := newBeegfsVolumeFromID(stagingTargetPath, volumeID, ns.pluginConfig)
+	beegfsFlags, bindFlags, err := splitMountFlags(volCap.GetMount().GetMountFlags())
 	if err != nil {
-		return nil, newGrpcErrorFromCause(codes.Internal, err)
+		return nil, newGrpcErrorFromCause(codes.InvalidArgument, err)
+	}
+
+	var vol beegfsVolume
+	var stripePatternConfig stripePatternConfig
+	if ephemeral {
+		pluginConfig, err := ns.loadConfig()
+		if err != nil {
+			return nil, newGrpcErrorFromCause(codes.Internal, err)
+		}
+		if vol, stripePatternConfig, err = ns.newEphemeralBeegfsVolume(volumeID, req.GetVolumeContext(),
+			pluginConfig); err != nil {
+			return nil, newGrpcErrorFromCause(codes.InvalidArgument, err)
+		}
+		if vol.config.AllowEphemeralVolumes == nil || !*vol.config.AllowEphemeralVolumes {
+			return nil, newGrpcErrorf(codes.PermissionDenied, "ephemeral volumes are not allowed on %s",
+				vol.sysMgmtdHost)
+		}
+		if err := applyTransport(&vol); err != nil {
+			return nil, newGrpcErrorFromCause(codes.FailedPrecondition, err)
+		}
+		vol.connAuth = connAuthFromSecrets(req.GetSecrets())
+	} else {
+		if vol, err = newBeegfsVolumeFromID(stagingTargetPath, volumeID, ns.configStore.load(), nil); err != nil {
+			return nil, newGrpcErrorFromCause(codes.Internal, err)
+		}
 	}
 
 	// Check to make sure file system is not already bind mounted
@@ -102,23 +174,34 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	}
 	if !notMnt {
 		// The filesystem is already mounted. There is nothing to do.
-		glog.V(LogDebug).Infof("%s is already mounted to %s", vol.volumeID, vol.mountPath)
+		newVolumeLogger(ctx, vol).V(LogDebug).Info("Volume is already mounted", "path", targetPath)
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
+	if ephemeral {
+		if err := ns.stageEphemeralVolume(ctx, vol, stripePatternConfig, beegfsFlags,
+			req.GetVolumeContext()); err != nil {
+			return nil, err
+		}
+	}
+
 	// Bind mount volDirPath onto TargetPath.
-	opts := []string{"bind"}
-	if readOnly {
-		// TODO(webere, A143): Get read-only mounts propagating outside of the plugin container.
-		// When the driver runs in a container (as is standard in a K8s deployment), the bind mount appears read-only
-		// within that container, but not outside the container (on the host or in another pod). K8s read-only mounts
-		// still work as expected (due to a "last-mile" read-only bind mount into the K8s pod), but read-only may not
-		// work as expected for other COs.
-		opts = append(opts, "ro")
-	}
-	glog.V(LogDebug).Infof("Mounting %s to %s with options %s", vol.volDirPath, targetPath, opts)
+	// TODO(webere, A143): Get read-only mounts propagating outside of the plugin container.
+	// When the driver runs in a container (as is standard in a K8s deployment), the bind mount appears read-only
+	// within that container, but not outside the container (on the host or in another pod). K8s read-only mounts
+	// still work as expected (due to a "last-mile" read-only bind mount into the K8s pod), but read-only may not
+	// work as expected for other COs.
+	opts := bindMountOpts(bindFlags, readOnly)
+	newVolumeLogger(ctx, vol).V(LogDebug).Info("Bind mounting volume", "source", vol.volDirPath, "path", targetPath,
+		"options", opts)
+	_, span := startSpan(ctx, "mount", attribute.String("beegfs.volume_id", vol.volumeID),
+		attribute.String("beegfs.mount_path", targetPath))
 	err = ns.mounter.Mount(vol.volDirPath, targetPath, "beegfs", opts)
+	endSpan(span, err)
 	if err != nil {
+		if ephemeral {
+			ns.rollBackEphemeralVolume(ctx, vol, true)
+		}
 		err = errors.WithStack(err)
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
 	}
@@ -137,11 +220,27 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, status.Error(codes.InvalidArgument, "Target path not provided")
 	}
 
-	glog.V(LogDebug).Infof("Unmounting %s from %s", volumeID, targetPath)
-	if err := mount.CleanupMountPoint(targetPath, ns.mounter, true); err != nil {
+	newLogger(ctx).V(LogDebug).Info("Unmounting volume", "volume_id", volumeID, "path", targetPath)
+	_, span := startSpan(ctx, "unmount", attribute.String("beegfs.volume_id", volumeID),
+		attribute.String("beegfs.mount_path", targetPath))
+	err := mount.CleanupMountPoint(targetPath, ns.mounter, true)
+	endSpan(span, err)
+	if err != nil {
+		err = errors.WithStack(err)
+		return nil, newGrpcErrorFromCause(codes.Internal, err)
+	}
+
+	// Only ephemeral volumes have a mountDirPath in ephemeralDataDir.
+	mountDirPath := ns.ephemeralMountDirPath(volumeID)
+	if _, err := fs.Stat(mountDirPath); err == nil {
+		if err := ns.deleteEphemeralVolume(ctx, mountDirPath); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
 		err = errors.WithStack(err)
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
 	}
+
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
@@ -162,11 +261,32 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	if valid, reason := isValidVolumeCapability(volCap); !valid {
 		return nil, status.Errorf(codes.InvalidArgument, "Volume capability not supported: %s", reason)
 	}
+	beegfsFlags, _, err := splitMountFlags(volCap.GetMount().GetMountFlags())
+	if err != nil {
+		return nil, newGrpcErrorFromCause(codes.InvalidArgument, err)
+	}
 
-	vol, err := newBeegfsVolumeFromID(stagingTargetPath, volumeID, ns.pluginConfig)
+	pluginConfig, err := ns.loadConfig()
+	if err != nil {
+		return nil, newGrpcErrorFromCause(codes.Internal, err)
+	}
+	volBeegfsClientConf, err := getBeegfsClientConfFromParams(req.GetVolumeContext(), pluginConfig)
+	if err != nil {
+		return nil, newGrpcErrorFromCause(codes.InvalidArgument, err)
+	}
+	transport, err := getTransportFromParams(req.GetVolumeContext())
+	if err != nil {
+		return nil, newGrpcErrorFromCause(codes.InvalidArgument, err)
+	}
+	vol, err := newBeegfsVolumeFromID(stagingTargetPath, volumeID, pluginConfig, volBeegfsClientConf)
 	if err != nil {
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
 	}
+	vol.connAuth = connAuthFromSecrets(req.GetSecrets())
+	vol.transport = transport
+	if err := applyTransport(&vol); err != nil {
+		return nil, newGrpcErrorFromCause(codes.FailedPrecondition, err)
+	}
 
 	// Ensure mountDirPath already exists (CO should have created req.StagingTargetPath).
 	_, err = fs.Stat(vol.mountDirPath)
@@ -175,14 +295,8 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
 	}
 
-	// Write configuration files and mount BeeGFS.
-	if err := writeClientFiles(vol, ns.clientConfTemplatePath); err != nil {
-		return nil, newGrpcErrorFromCause(codes.Internal, err)
-	}
-	if err := mountIfNecessary(vol, ns.mounter); err != nil {
-		// TODO(webere, A144): Return the appropriate codes.NOT_FOUND if the problem is that we can't find the volume.
-		// https://github.com/container-storage-interface/spec/blob/master/spec.md#nodestagevolume-errors
-		return nil, newGrpcErrorFromCause(codes.Internal, err)
+	if err := ns.writeClientFilesAndMount(ctx, vol, beegfsFlags); err != nil {
+		return nil, err
 	}
 
 	return &csi.NodeStageVolumeResponse{}, nil
@@ -199,12 +313,12 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Error(codes.InvalidArgument, "Staging target path not provided")
 	}
 
-	vol, err := newBeegfsVolumeFromID(stagingTargetPath, volumeID, ns.pluginConfig)
+	vol, err := newBeegfsVolumeFromID(stagingTargetPath, volumeID, ns.configStore.load(), nil)
 	if err != nil {
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
 	}
 
-	err = unmountAndCleanUpIfNecessary(vol, false, ns.mounter) // The CO will clean up mountDirPath.
+	err = unmountAndCleanUpIfNecessary(ctx, vol, false, ns.mounter) // The CO will clean up mountDirPath.
 	if err != nil {
 		return nil, newGrpcErrorFromCause(codes.Internal, err)
 	}
@@ -212,9 +326,25 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
+// NodeGetInfo returns the maximum number of volumes and the topology segments configured for the node (see
+// nodeInfoConfig). The container orchestrator only calls NodeGetInfo when the node plugin registers, so changes to the
+// node information take effect when the driver restarts. NodeGetInfo also resolves connInterfacesSelectors, so the
+// interfaces the node selects are logged (and any failure to list them is reported) as soon as the node plugin starts.
 func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	pluginConfig, err := ns.loadConfig()
+	if err != nil {
+		return nil, newGrpcErrorFromCause(codes.Internal, err)
+	}
+	nodeInfo := pluginConfig.NodeInfo
+	// The driver advertises VOLUME_ACCESSIBILITY_CONSTRAINTS, so it always reports a (possibly empty) topology.
+	segments := make(map[string]string, len(nodeInfo.TopologySegments))
+	for k, v := range nodeInfo.TopologySegments {
+		segments[k] = v
+	}
 	return &csi.NodeGetInfoResponse{
-		NodeId: ns.nodeID,
+		NodeId:             ns.nodeID,
+		MaxVolumesPerNode:  nodeInfo.MaxVolumesPerNode,
+		AccessibleTopology: &csi.Topology{Segments: segments},
 	}, nil
 }
 
@@ -240,3 +370,272 @@ func (ns *nodeServer) NodeGetVolumeStats(ctx context.Context, in *csi.NodeGetVol
 func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "")
 }
+
+// newEphemeralBeegfsVolume creates a beegfsVolume for an ephemeral volume from the parameters in its volume context.
+// The volume's BeeGFS directory is named after the (unique) volumeID the CO generated for it and is created under
+// volDirBasePath.
+func (ns *nodeServer) newEphemeralBeegfsVolume(volumeID string, volContext map[string]string,
+	pluginConfig pluginConfig) (beegfsVolume, stripePatternConfig, error) {
+	fileSystem, err := getFileSystemFromParams(volContext, pluginConfig)
+	if err != nil {
+		return beegfsVolume{}, stripePatternConfig{}, err
+	}
+	volDirBasePathBeegfsRoot, ok := volContext[volDirBasePathKey]
+	if !ok {
+		return beegfsVolume{}, stripePatternConfig{}, errors.Errorf("%s not provided", volDirBasePathKey)
+	}
+	volDirBasePathBeegfsRoot = path.Clean(path.Join("/", volDirBasePathBeegfsRoot))
+	stripePatternConfig, err := getStripePatternParamsFromRequest(volContext)
+	if err != nil {
+		return beegfsVolume{}, stripePatternConfig, err
+	}
+	volBeegfsClientConf, err := getBeegfsClientConfFromParams(volContext, pluginConfig)
+	if err != nil {
+		return beegfsVolume{}, stripePatternConfig, err
+	}
+	transport, err := getTransportFromParams(volContext)
+	if err != nil {
+		return beegfsVolume{}, stripePatternConfig, err
+	}
+
+	volDirPathBeegfsRoot := path.Join(volDirBasePathBeegfsRoot, sanitizeVolumeID(volumeID))
+	vol := newBeegfsVolume(ns.ephemeralMountDirPath(volumeID), fileSystem, volDirPathBeegfsRoot, pluginConfig,
+		volBeegfsClientConf)
+	vol.transport = transport
+	return vol, stripePatternConfig, nil
+}
+
+// stageEphemeralVolume does for an ephemeral volume what CreateVolume and NodeStageVolume do for a persistent volume.
+// It writes client configuration files, mounts the BeeGFS file system, and uses beegfs-ctl to create the volume's
+// BeeGFS directory. It also records the volume's BeeGFS directory and volContext so that deleteEphemeralVolume can
+// find and mount it later. If any step fails, stageEphemeralVolume rolls back the steps that succeeded (see
+// rollBackEphemeralVolume).
+func (ns *nodeServer) stageEphemeralVolume(ctx context.Context, vol beegfsVolume, stripePatternConfig stripePatternConfig,
+	mountFlags []string, volContext map[string]string) (err error) {
+	newVolumeLogger(ctx, vol).V(LogDebug).Info("Staging ephemeral volume", "path", vol.mountDirPath)
+	if err := fs.MkdirAll(vol.mountDirPath, 0750); err != nil {
+		err = errors.WithStack(err)
+		return newGrpcErrorFromCause(codes.Internal, err)
+	}
+	dirCreated := false
+	defer func() {
+		if err != nil {
+			ns.rollBackEphemeralVolume(ctx, vol, dirCreated)
+		}
+	}()
+	volumeIDFilePath := path.Join(vol.mountDirPath, ephemeralVolumeIDFileName)
+	if err := fsutil.WriteFile(volumeIDFilePath, []byte(vol.volumeID), 0644); err != nil {
+		err = errors.Wrap(err, "error writing ephemeral volume ID file")
+		return newGrpcErrorFromCause(codes.Internal, err)
+	}
+	volContextBytes, err := json.Marshal(volContext)
+	if err != nil {
+		err = errors.Wrap(err, "error marshaling ephemeral volume context")
+		return newGrpcErrorFromCause(codes.Internal, err)
+	}
+	volContextFilePath := path.Join(vol.mountDirPath, ephemeralVolumeContextFileName)
+	if err := fsutil.WriteFile(volContextFilePath, volContextBytes, 0644); err != nil {
+		err = errors.Wrap(err, "error writing ephemeral volume context file")
+		return newGrpcErrorFromCause(codes.Internal, err)
+	}
+	// Mount before creating the BeeGFS directory so that the directory can be deleted if a later step fails.
+	if err := ns.writeClientFilesAndMount(ctx, vol, mountFlags); err != nil {
+		return err
+	}
+	if err := ns.ctlExec.createDirectoryForVolume(ctx, vol); err != nil {
+		return newGrpcErrorFromCause(codes.Internal, err)
+	}
+	dirCreated = true
+	if err := ns.ctlExec.setPatternForVolume(ctx, vol, stripePatternConfig); err != nil {
+		return newGrpcErrorFromCause(codes.Internal, err)
+	}
+	return nil
+}
+
+// writeClientFilesAndMount writes client configuration files to vol.mountDirPath and mounts the BeeGFS file system.
+// Another process may bind the selected connClientPortUDP before the BeeGFS client does. If that causes the mount to
+// fail, writeClientFilesAndMount tries again with a different port (up to maxMountAttempts times). The returned error
+// is a grpcError.
+func (ns *nodeServer) writeClientFilesAndMount(ctx context.Context, vol beegfsVolume, mountFlags []string) error {
+	for attempt := 1; ; attempt++ {
+		if err := writeClientFiles(ctx, vol, ns.clientConfTemplatePath, ns.udpPorts); err != nil {
+			return newGrpcErrorFromCause(clientFilesErrorCode(err), err)
+		}
+		mountErr := mountIfNecessary(ctx, vol, mountFlags, ns.mounter)
+		if mountErr == nil {
+			return nil
+		}
+		if attempt < maxMountAttempts {
+			released, err := ns.udpPorts.releaseIfConflicting(vol.mountDirPath)
+			if err != nil {
+				newVolumeLogger(ctx, vol).Error(err, "Failed to check connClientPortUDP for conflicts")
+			} else if released {
+				newVolumeLogger(ctx, vol).Info("Retrying mount with a different connClientPortUDP", "attempt",
+					attempt, "error", mountErr.Error())
+				continue
+			}
+		}
+		// TODO(webere, A144): Return the appropriate codes.NOT_FOUND if the problem is that we can't find the volume.
+		// https://github.com/container-storage-interface/spec/blob/master/spec.md#nodestagevolume-errors
+		return newGrpcErrorFromCause(codes.Internal, mountErr)
+	}
+}
+
+// rollBackEphemeralVolume undoes what stageEphemeralVolume did for an ephemeral volume before it failed. It deletes the
+// volume's BeeGFS directory if dirCreated is true, unmounts the BeeGFS file system if it is mounted, and deletes
+// mountDirPath. rollBackEphemeralVolume logs failures instead of returning them so that the error that caused the
+// roll back is returned to the CO.
+func (ns *nodeServer) rollBackEphemeralVolume(ctx context.Context, vol beegfsVolume, dirCreated bool) {
+	logger := newVolumeLogger(ctx, vol)
+	logger.Info("Rolling back ephemeral volume", "path", vol.mountDirPath)
+	if dirCreated {
+		if err := fs.RemoveAll(vol.volDirPath); err != nil {
+			logger.Error(errors.WithStack(err), "Failed to delete BeeGFS directory for ephemeral volume",
+				"path", vol.volDirPathBeegfsRoot)
+		}
+	}
+	notMnt, err := ns.mounter.IsLikelyNotMountPoint(vol.mountPath)
+	if err == nil && !notMnt {
+		err = unmountAndCleanUpIfNecessary(ctx, vol, true, ns.mounter)
+	} else {
+		err = cleanUpIfNecessary(ctx, vol, true)
+	}
+	if err != nil {
+		logger.Error(err, "Failed to clean up ephemeral volume", "path", vol.mountDirPath)
+	}
+}
+
+// deleteEphemeralVolume deletes the BeeGFS directory associated with an ephemeral volume and cleans up the mount and
+// configuration files in mountDirPath. deleteEphemeralVolume must only be called after the volume has been unmounted
+// from its target path. If the file system has to be mounted again, it is mounted with the beegfsClientConf overrides
+// and transport recorded in the volume's context. An override or transport that can no longer be applied (e.g.
+// because the configuration or the node's interfaces changed) is logged and ignored, so that the volume can still be
+// deleted.
+func (ns *nodeServer) deleteEphemeralVolume(ctx context.Context, mountDirPath string) error {
+	volumeIDBytes, err := fsutil.ReadFile(path.Join(mountDirPath, ephemeralVolumeIDFileName))
+	if err != nil {
+		err = errors.Wrap(err, "error reading ephemeral volume ID file")
+		return newGrpcErrorFromCause(codes.Internal, err)
+	}
+	volumeID := strings.TrimSpace(string(volumeIDBytes))
+	var volContext map[string]string
+	if volContextBytes, err := fsutil.ReadFile(path.Join(mountDirPath, ephemeralVolumeContextFileName)); err == nil {
+		if err := json.Unmarshal(volContextBytes, &volContext); err != nil {
+			err = errors.Wrap(err, "error parsing ephemeral volume context file")
+			return newGrpcErrorFromCause(codes.Internal, err)
+		}
+	} else if !os.IsNotExist(err) {
+		err = errors.Wrap(err, "error reading ephemeral volume context file")
+		return newGrpcErrorFromCause(codes.Internal, err)
+	}
+	pluginConfig, err := ns.loadConfig()
+	if err != nil {
+		return newGrpcErrorFromCause(codes.Internal, err)
+	}
+	volBeegfsClientConf, err := getBeegfsClientConfFromParams(volContext, pluginConfig)
+	if err != nil {
+		newLogger(ctx).Error(err, "Ignoring beegfsClientConf overrides of ephemeral volume", "volume_id", volumeID)
+		volBeegfsClientConf = nil
+	}
+	vol, err := newBeegfsVolumeFromID(mountDirPath, volumeID, pluginConfig, volBeegfsClientConf)
+	if err != nil {
+		return newGrpcErrorFromCause(codes.Internal, err)
+	}
+	if vol.transport, err = getTransportFromParams(volContext); err == nil {
+		err = applyTransport(&vol)
+	}
+	if err != nil {
+		newVolumeLogger(ctx, vol).Error(err, "Ignoring transport of ephemeral volume")
+		vol.transport = ""
+	}
+
+	// The file system is normally still mounted, but may not be (e.g. if the node was rebooted).
+	if _, err := fs.Stat(vol.clientConfPath); err != nil {
+		if err := writeClientFiles(ctx, vol, ns.clientConfTemplatePath, ns.udpPorts); err != nil {
+			return newGrpcErrorFromCause(clientFilesErrorCode(err), err)
+		}
+	}
+	if err := mountIfNecessary(ctx, vol, nil, ns.mounter); err != nil {
+		return newGrpcErrorFromCause(codes.Internal, err)
+	}
+
+	newVolumeLogger(ctx, vol).V(LogDebug).Info("Deleting BeeGFS directory for ephemeral volume",
+		"path", vol.volDirPathBeegfsRoot)
+	if err := fs.RemoveAll(vol.volDirPath); err != nil {
+		err = errors.WithStack(err)
+		return newGrpcErrorFromCause(codes.Internal, err)
+	}
+	if err := unmountAndCleanUpIfNecessary(ctx, vol, true, ns.mounter); err != nil {
+		return newGrpcErrorFromCause(codes.Internal, err)
+	}
+	return nil
+}
+
+// ephemeralMountDirPath returns the mountDirPath the node service uses for an ephemeral volume.
+func (ns *nodeServer) ephemeralMountDirPath(volumeID string) string {
+	return path.Join(ns.ephemeralDataDir, sanitizeVolumeID(volumeID)) // e.g. /csDataDir/ephemeral/csi-0123456789abcdef
+}
+
+// checkMountPoint returns an error if mountPath cannot be accessed. It is a variable so that tests can simulate
+// corrupted mounts.
+var checkMountPoint = func(mountPath string) error {
+	_, err := fs.Stat(mountPath)
+	return err
+}
+
+// restoreStagedMounts repairs BeeGFS file systems this node service mounted (in NodeStageVolume or, for ephemeral
+// volumes, NodePublishVolume) that are no longer accessible. This can happen if the node service container or the
+// BeeGFS client restarts. NodeStageVolume only checks whether a file system is mounted, so it cannot repair these mounts
+// itself. A mount is identified by its cfgFile mount option and is remounted with the configuration files still
+// present in its mountDirPath. Bind mounts of a repaired file system (e.g. into running Pods) are not repaired.
+// restoreStagedMounts logs failures to repair individual mounts instead of returning them.
+func (ns *nodeServer) restoreStagedMounts() error {
+	allMounts, err := ns.mounter.List()
+	if err != nil {
+		return errors.Wrap(err, "error listing mounted filesystems")
+	}
+	// ephemeralDataDir is always a subdirectory of csDataDir.
+	csDataDir := path.Dir(ns.ephemeralDataDir)
+	for _, entry := range allMounts {
+		clientConfPath := cfgFileFromMountOpts(entry.Opts)
+		if entry.Device != "beegfs_nodev" || clientConfPath == "" {
+			continue
+		}
+		mountDirPath := path.Dir(clientConfPath)
+		if entry.Path != path.Join(mountDirPath, "mount") {
+			// This is a bind mount or a duplicate of a mount under /host.
+			continue
+		}
+		if path.Dir(mountDirPath) == csDataDir {
+			// The controller service is responsible for mounts in its own csDataDir.
+			continue
+		}
+
+		err := checkMountPoint(entry.Path)
+		if err == nil {
+			klog.V(LogDebug).InfoS("BeeGFS file system is healthy", "path", entry.Path)
+			continue
+		}
+		if !mount.IsCorruptedMnt(err) {
+			klog.InfoS("Skipping BeeGFS file system", "path", entry.Path, "reason", err)
+			continue
+		}
+		if _, err := fs.Stat(clientConfPath); err != nil {
+			klog.ErrorS(err, "Unable to restore corrupted BeeGFS file system", "path", entry.Path)
+			continue
+		}
+
+		mountOpts := beegfsMountOpts(clientConfPath, beegfsFlagsFromMountOpts(entry.Opts))
+		klog.InfoS("Restoring corrupted BeeGFS file system", "path", entry.Path, "options", mountOpts)
+		if err := ns.mounter.Unmount(entry.Path); err != nil {
+			klog.ErrorS(err, "Failed to unmount corrupted BeeGFS file system", "path", entry.Path)
+			continue
+		}
+		if err := ns.mounter.Mount("beegfs_nodev", entry.Path, "beegfs", mountOpts); err != nil {
+			klog.ErrorS(err, "Failed to remount BeeGFS file system", "path", entry.Path)
+			continue
+		}
+		klog.InfoS("Restored BeeGFS file system", "path", entry.Path)
+	}
+	return nil
+}
@@ -0,0 +1,621 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/mount"
+)
+
+func TestNewEphemeralBeegfsVolume(t *testing.T) {
+	config := pluginConfig{FileSystems: []namedFileSystem{{Name: "scratch", SysMgmtdHost: "127.0.0.1"}}}
+	ns := NewNodeServer("testnode", newPluginConfigStore(config), "/etc/beegfs/beegfs-client.conf", "/csDataDir/ephemeral")
+	volumeID := "csi-0123456789abcdef"
+
+	tests := map[string]struct {
+		volContext       map[string]string
+		wantVolumeID     string
+		wantMountDirPath string
+		wantStripe       stripePatternConfig
+		wantErr          bool
+	}{
+		"basic example": {
+			volContext: map[string]string{
+				sysMgmtdHostKey:   "127.0.0.1",
+				volDirBasePathKey: "k8s/name/inline",
+				ephemeralKey:      "true",
+			},
+			wantVolumeID:     "beegfs://127.0.0.1/k8s/name/inline/csi-0123456789abcdef",
+			wantMountDirPath: "/csDataDir/ephemeral/csi-0123456789abcdef",
+		},
+		"stripe pattern example": {
+			volContext: map[string]string{
+				sysMgmtdHostKey:            "127.0.0.1",
+				volDirBasePathKey:          "/k8s/name/inline/",
+				stripePatternNumTargetsKey: "4",
+			},
+			wantVolumeID:     "beegfs://127.0.0.1/k8s/name/inline/csi-0123456789abcdef",
+			wantMountDirPath: "/csDataDir/ephemeral/csi-0123456789abcdef",
+			wantStripe:       stripePatternConfig{stripePatternNumTargets: "4"},
+		},
+		"fsName example": {
+			volContext: map[string]string{
+				fsNameKey:         "scratch",
+				volDirBasePathKey: "k8s/name/inline",
+			},
+			wantVolumeID:     "beegfs://scratch/k8s/name/inline/csi-0123456789abcdef",
+			wantMountDirPath: "/csDataDir/ephemeral/csi-0123456789abcdef",
+		},
+		"unknown fsName example": {
+			volContext: map[string]string{
+				fsNameKey:         "home",
+				volDirBasePathKey: "k8s/name/inline",
+			},
+			wantErr: true,
+		},
+		"fsName and sysMgmtdHost example": {
+			volContext: map[string]string{
+				fsNameKey:         "scratch",
+				sysMgmtdHostKey:   "127.0.0.1",
+				volDirBasePathKey: "k8s/name/inline",
+			},
+			wantErr: true,
+		},
+		"invalid sysMgmtdHost example": {
+			volContext: map[string]string{
+				sysMgmtdHostKey:   "mgmtd_01",
+				volDirBasePathKey: "k8s/name/inline",
+			},
+			wantErr: true,
+		},
+		"missing sysMgmtdHost example": {
+			volContext: map[string]string{
+				volDirBasePathKey: "k8s/name/inline",
+			},
+			wantErr: true,
+		},
+		"missing volDirBasePath example": {
+			volContext: map[string]string{
+				sysMgmtdHostKey: "127.0.0.1",
+			},
+			wantErr: true,
+		},
+		"invalid stripe pattern example": {
+			volContext: map[string]string{
+				sysMgmtdHostKey:           "127.0.0.1",
+				volDirBasePathKey:         "k8s/name/inline",
+				"stripePattern/numtarget": "4",
+			},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			vol, stripe, err := ns.newEphemeralBeegfsVolume(volumeID, tc.volContext, ns.configStore.load())
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error to occur for volume context: %v", tc.volContext)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+			if tc.wantVolumeID != vol.volumeID {
+				t.Fatalf("expected volumeID: %s, got volumeID: %s", tc.wantVolumeID, vol.volumeID)
+			}
+			if tc.wantMountDirPath != vol.mountDirPath {
+				t.Fatalf("expected mountDirPath: %s, got mountDirPath: %s", tc.wantMountDirPath, vol.mountDirPath)
+			}
+			if tc.wantStripe != stripe {
+				t.Fatalf("expected stripePatternConfig: %v, got stripePatternConfig: %v", tc.wantStripe, stripe)
+			}
+		})
+	}
+}
+
+func TestRestoreStagedMounts(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	defer func(orig func(string) error) { checkMountPoint = orig }(checkMountPoint)
+
+	const (
+		healthyDir    = "/staging/healthy"
+		corruptedDir  = "/staging/corrupted"
+		noConfDir     = "/staging/noconf"
+		ephemeralDir  = "/csDataDir/ephemeral/corrupted"
+		controllerDir = "/csDataDir/corrupted"
+	)
+	for _, dir := range []string{healthyDir, corruptedDir, ephemeralDir, controllerDir} {
+		if err := fsutil.WriteFile(path.Join(dir, "beegfs-client.conf"), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	corrupted := map[string]bool{
+		path.Join(corruptedDir, "mount"):  true,
+		path.Join(noConfDir, "mount"):     true,
+		path.Join(ephemeralDir, "mount"):  true,
+		path.Join(controllerDir, "mount"): true,
+		"/target/corrupted":               true,
+	}
+	checkMountPoint = func(mountPath string) error {
+		if corrupted[mountPath] {
+			return &os.PathError{Op: "stat", Path: mountPath, Err: syscall.ENOTCONN}
+		}
+		return nil
+	}
+
+	beegfsMount := func(mountPath, mountDirPath string, opts ...string) mount.MountPoint {
+		opts = append(opts, "cfgFile="+path.Join(mountDirPath, "beegfs-client.conf"))
+		return mount.MountPoint{Device: "beegfs_nodev", Path: mountPath, Type: "beegfs", Opts: opts}
+	}
+	mounter := mount.NewFakeMounter([]mount.MountPoint{
+		beegfsMount(path.Join(healthyDir, "mount"), healthyDir, "rw", "relatime"),
+		beegfsMount(path.Join(corruptedDir, "mount"), corruptedDir, "rw", "relatime"),
+		beegfsMount("/host"+path.Join(corruptedDir, "mount"), corruptedDir, "rw", "noatime"),
+		beegfsMount("/target/corrupted", corruptedDir, "rw", "nosuid"),
+		beegfsMount(path.Join(noConfDir, "mount"), noConfDir, "rw", "relatime"),
+		beegfsMount(path.Join(ephemeralDir, "mount"), ephemeralDir, "rw", "noatime", "logLevel=5", "_netdev"),
+		beegfsMount(path.Join(controllerDir, "mount"), controllerDir, "rw", "relatime"),
+		{Device: "/dev/sda1", Path: "/", Type: "ext4", Opts: []string{"rw"}},
+	})
+	ns := NewNodeServer("testnode", newPluginConfigStore(pluginConfig{}), "/etc/beegfs/beegfs-client.conf", "/csDataDir/ephemeral")
+	ns.mounter = mounter
+
+	if err := ns.restoreStagedMounts(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	wantActions := []mount.FakeAction{
+		{Action: mount.FakeActionUnmount, Target: path.Join(corruptedDir, "mount")},
+		{Action: mount.FakeActionMount, Target: path.Join(corruptedDir, "mount"), Source: "beegfs_nodev",
+			FSType: "beegfs"},
+		{Action: mount.FakeActionUnmount, Target: path.Join(ephemeralDir, "mount")},
+		{Action: mount.FakeActionMount, Target: path.Join(ephemeralDir, "mount"), Source: "beegfs_nodev",
+			FSType: "beegfs"},
+	}
+	if !reflect.DeepEqual(wantActions, mounter.GetLog()) {
+		t.Fatalf("expected actions: %v, got: %v", wantActions, mounter.GetLog())
+	}
+
+	// FakeMounter discards the options of all other mount points on Unmount, so only check the last one mounted.
+	wantOpts := []string{"rw", "noatime", "logLevel=5", "cfgFile=" + path.Join(ephemeralDir, "beegfs-client.conf")}
+	for _, mp := range mounter.MountPoints {
+		if mp.Path == path.Join(ephemeralDir, "mount") && !reflect.DeepEqual(wantOpts, mp.Opts) {
+			t.Fatalf("expected options: %v, got: %v", wantOpts, mp.Opts)
+		}
+	}
+}
+
+// failingMounter is a mount.Interface whose Mount fails the first failures times it is called. Before each failure, it
+// calls onFailure.
+type failingMounter struct {
+	*mount.FakeMounter
+	failures  int
+	onFailure func()
+}
+
+func (m *failingMounter) Mount(source, target, fstype string, options []string) error {
+	if m.failures > 0 {
+		m.failures--
+		m.onFailure()
+		return errors.New("mount failed")
+	}
+	return m.FakeMounter.Mount(source, target, fstype, options)
+}
+
+func TestNodeStageVolumePortConflict(t *testing.T) {
+	fs = afero.NewOsFs() // mount.FakeMounter uses the real file system to check mount points
+	fsutil = afero.Afero{Fs: fs}
+	tmpDir, err := ioutil.TempDir("", "port-conflict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	confTemplatePath := path.Join(tmpDir, "beegfs-client.conf")
+	if err := fsutil.WriteFile(confTemplatePath, []byte(TestWriteClientFilesTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		portRange    string
+		failures     int  // number of times Mount fails
+		conflict     bool // whether Mount fails because the selected port is bound by another process
+		externalPort int  // a port bound by another process from the start
+		wantPort     string
+		wantCode     codes.Code
+	}{
+		"no failure":    {portRange: "40000-40009", wantPort: "40000"},
+		"port conflict": {portRange: "40000-40009", failures: 1, conflict: true, wantPort: "40001"},
+		"other failure": {portRange: "40000-40009", failures: 1, wantCode: codes.Internal},
+		"repeated conflict": {
+			portRange: "40000-40009",
+			failures:  maxMountAttempts,
+			conflict:  true,
+			wantCode:  codes.Internal,
+		},
+		"range exhausted": {portRange: "40000", externalPort: 40000, wantCode: codes.ResourceExhausted},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			csDataDir := path.Join(tmpDir, name, "csDataDir")
+			stagingTargetPath := path.Join(tmpDir, name, "staging")
+			if err := fs.MkdirAll(stagingTargetPath, 0750); err != nil {
+				t.Fatal(err)
+			}
+			config := pluginConfig{DefaultConfig: beegfsConfig{ConnClientPortUDPRange: tc.portRange}}
+			ns := NewNodeServer("testnode", newPluginConfigStore(config), confTemplatePath,
+				path.Join(csDataDir, ephemeralDirName))
+			bound := map[int]bool{tc.externalPort: true}
+			ns.udpPorts.isPortFree = func(port int) bool { return !bound[port] }
+			ns.mounter = &failingMounter{
+				FakeMounter: mount.NewFakeMounter(nil),
+				failures:    tc.failures,
+				onFailure: func() {
+					if !tc.conflict {
+						return
+					}
+					assignments, err := ns.udpPorts.load()
+					if err != nil {
+						t.Fatal(err)
+					}
+					bound[assignments[stagingTargetPath]] = true
+				},
+			}
+
+			_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          "beegfs://127.0.0.1/scratch/vol1",
+				StagingTargetPath: stagingTargetPath,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+			})
+			if tc.wantCode != codes.OK {
+				var grpcErr grpcError
+				if !errors.As(err, &grpcErr) || status.Code(grpcErr.GetStatusErr()) != tc.wantCode {
+					t.Fatalf("expected error with code %s, got: %v", tc.wantCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+			clientConf, err := fsutil.ReadFile(path.Join(stagingTargetPath, "beegfs-client.conf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "connClientPortUDP     = " + tc.wantPort + "\n"; !strings.Contains(string(clientConf), want) {
+				t.Fatalf("expected beegfs-client.conf to contain %q, got:\n%s", want, clientConf)
+			}
+		})
+	}
+}
+
+// ephemeralCtlExecutor is a beegfsCtlExecutorInterface that creates volume directories directly on fs (as the BeeGFS
+// file system is not really mounted) and fails setPatternForVolume with setPatternErr.
+type ephemeralCtlExecutor struct {
+	fakeBeegfsCtlExecutor
+	setPatternErr error
+}
+
+func (*ephemeralCtlExecutor) createDirectoryForVolume(ctx context.Context, vol beegfsVolume) error {
+	return fs.MkdirAll(vol.volDirPath, 0750)
+}
+
+func (e *ephemeralCtlExecutor) setPatternForVolume(ctx context.Context, vol beegfsVolume,
+	config stripePatternConfig) error {
+	return e.setPatternErr
+}
+
+// bindFailingMounter is a mount.Interface whose bind mounts fail.
+type bindFailingMounter struct {
+	*mount.FakeMounter
+}
+
+func (m *bindFailingMounter) Mount(source, target, fstype string, options []string) error {
+	for _, option := range options {
+		if option == "bind" {
+			return errors.New("bind mount failed")
+		}
+	}
+	return m.FakeMounter.Mount(source, target, fstype, options)
+}
+
+func TestNodePublishUnpublishEphemeralVolume(t *testing.T) {
+	fs = afero.NewOsFs() // mount.FakeMounter uses the real file system to check mount points
+	fsutil = afero.Afero{Fs: fs}
+	tmpDir, err := ioutil.TempDir("", "ephemeral")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	confTemplatePath := path.Join(tmpDir, "beegfs-client.conf")
+	if err := fsutil.WriteFile(confTemplatePath, []byte(TestWriteClientFilesTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+	allowEphemeralVolumes := true
+	config := pluginConfig{DefaultConfig: beegfsConfig{
+		AllowEphemeralVolumes:  &allowEphemeralVolumes,
+		ConnClientPortUDPRange: "40000-40009",
+	}}
+	const volumeID = "csi-0123456789abcdef"
+	// The BeeGFS file system is not really mounted, so ephemeralCtlExecutor creates volume directories in mountPath.
+	// Use the file system root as volDirBasePath so that deleting the volume directory leaves mountPath empty.
+	volContext := map[string]string{
+		ephemeralKey:      "true",
+		sysMgmtdHostKey:   "127.0.0.1",
+		volDirBasePathKey: "/",
+	}
+
+	tests := map[string]struct {
+		mountFailures int   // number of times the BeeGFS mount fails
+		conflict      bool  // whether the BeeGFS mount fails because the selected port is bound by another process
+		failBind      bool  // whether the bind mount onto the target path fails
+		setPatternErr error // error setPatternForVolume returns
+		wantPort      string
+		wantCode      codes.Code
+	}{
+		"success":           {wantPort: "40000"},
+		"port conflict":     {mountFailures: 1, conflict: true, wantPort: "40001"},
+		"mount fails":       {mountFailures: 1, wantCode: codes.Internal},
+		"set pattern fails": {setPatternErr: errors.New("beegfs-ctl failed"), wantCode: codes.Internal},
+		"bind mount fails":  {failBind: true, wantCode: codes.Internal},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ns := NewNodeServer("testnode", newPluginConfigStore(config), confTemplatePath,
+				path.Join(tmpDir, name, "csDataDir", ephemeralDirName))
+			ns.ctlExec = &ephemeralCtlExecutor{setPatternErr: tc.setPatternErr}
+			vol, _, err := ns.newEphemeralBeegfsVolume(volumeID, volContext, ns.configStore.load())
+			if err != nil {
+				t.Fatal(err)
+			}
+			bound := map[int]bool{}
+			ns.udpPorts.isPortFree = func(port int) bool { return !bound[port] }
+			fakeMounter := mount.NewFakeMounter(nil)
+			ns.mounter = &failingMounter{
+				FakeMounter: fakeMounter,
+				failures:    tc.mountFailures,
+				onFailure: func() {
+					if !tc.conflict {
+						return
+					}
+					assignments, err := ns.udpPorts.load()
+					if err != nil {
+						t.Fatal(err)
+					}
+					bound[assignments[vol.mountDirPath]] = true
+				},
+			}
+			if tc.failBind {
+				ns.mounter = &bindFailingMounter{FakeMounter: fakeMounter}
+			}
+			targetPath := path.Join(tmpDir, name, "target")
+
+			_, err = ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:      volumeID,
+				TargetPath:    targetPath,
+				VolumeContext: volContext,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			})
+			if tc.wantCode != codes.OK {
+				var grpcErr grpcError
+				if !errors.As(err, &grpcErr) || status.Code(grpcErr.GetStatusErr()) != tc.wantCode {
+					t.Fatalf("expected error with code %s, got: %v", tc.wantCode, err)
+				}
+				// Nothing may be left behind, neither on the node nor in BeeGFS.
+				for _, p := range []string{vol.volDirPath, vol.mountDirPath} {
+					if _, err := fs.Stat(p); !os.IsNotExist(err) {
+						t.Fatalf("expected %s to be removed, got: %v", p, err)
+					}
+				}
+				if notMnt, _ := fakeMounter.IsLikelyNotMountPoint(vol.mountPath); !notMnt {
+					t.Fatalf("expected %s to be unmounted", vol.mountPath)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+			if notMnt, err := fakeMounter.IsLikelyNotMountPoint(targetPath); err != nil || notMnt {
+				t.Fatalf("expected %s to be mounted, got: %v", targetPath, err)
+			}
+			if _, err := fs.Stat(vol.volDirPath); err != nil {
+				t.Fatalf("expected BeeGFS directory to be created, got: %v", err)
+			}
+			clientConf, err := fsutil.ReadFile(vol.clientConfPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "connClientPortUDP     = " + tc.wantPort + "\n"; !strings.Contains(string(clientConf), want) {
+				t.Fatalf("expected beegfs-client.conf to contain %q, got:\n%s", want, clientConf)
+			}
+
+			_, err = ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   volumeID,
+				TargetPath: targetPath,
+			})
+			if err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+			for _, p := range []string{targetPath, vol.volDirPath, vol.mountDirPath} {
+				if _, err := fs.Stat(p); !os.IsNotExist(err) {
+					t.Fatalf("expected %s to be removed, got: %v", p, err)
+				}
+			}
+		})
+	}
+}
+
+// recordingMounter is a mount.Interface that records the beegfs-client.conf and connInterfacesFile in a BeeGFS file
+// system's mountDirPath whenever it mounts one.
+type recordingMounter struct {
+	*mount.FakeMounter
+	clientConfs    []string
+	connInterfaces []string
+}
+
+func (m *recordingMounter) Mount(source, target, fstype string, options []string) error {
+	if source == "beegfs_nodev" {
+		mountDirPath := path.Dir(target)
+		clientConf, _ := fsutil.ReadFile(path.Join(mountDirPath, "beegfs-client.conf"))
+		connInterfaces, _ := fsutil.ReadFile(path.Join(mountDirPath, "connInterfacesFile"))
+		m.clientConfs = append(m.clientConfs, regexp.MustCompile(` +`).ReplaceAllString(string(clientConf), " "))
+		m.connInterfaces = append(m.connInterfaces, string(connInterfaces))
+	}
+	return m.FakeMounter.Mount(source, target, fstype, options)
+}
+
+func TestNodeUnpublishEphemeralVolumeRemount(t *testing.T) {
+	fs = afero.NewOsFs() // mount.FakeMounter uses the real file system to check mount points
+	fsutil = afero.Afero{Fs: fs}
+	tmpDir, err := ioutil.TempDir("", "ephemeral-remount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	confTemplatePath := path.Join(tmpDir, "beegfs-client.conf")
+	template := TestWriteClientFilesTemplate + "connUseRDMA = true\nconnAuthFile =\ntuneFileCacheType = buffered\n"
+	if err := fsutil.WriteFile(confTemplatePath, []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+	allowEphemeralVolumes := true
+	config := pluginConfig{
+		DefaultConfig: beegfsConfig{
+			AllowEphemeralVolumes:   &allowEphemeralVolumes,
+			ConnInterfacesSelectors: []string{"eth*"},
+		},
+		AllowedVolumeBeegfsClientConf: []string{"tuneFileCacheType"},
+	}
+	const volumeID = "csi-0123456789abcdef"
+	volContext := map[string]string{
+		ephemeralKey:      "true",
+		sysMgmtdHostKey:   "127.0.0.1",
+		volDirBasePathKey: "/", // see TestNodePublishUnpublishEphemeralVolume
+		transportKey:      transportTCP,
+		beegfsClientConfKeyPrefix + "tuneFileCacheType": "native",
+	}
+	ns := NewNodeServer("testnode", newPluginConfigStore(config), confTemplatePath,
+		path.Join(tmpDir, "csDataDir", ephemeralDirName))
+	ns.ctlExec = &ephemeralCtlExecutor{}
+	ns.ifaceLister = &fakeInterfaceLister{ifaces: testInterfaces}
+	mounter := &recordingMounter{FakeMounter: mount.NewFakeMounter(nil)}
+	ns.mounter = mounter
+	targetPath := path.Join(tmpDir, "target")
+
+	_, err = ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:      volumeID,
+		TargetPath:    targetPath,
+		VolumeContext: volContext,
+		Secrets:       map[string]string{connAuthSecretKey: "secret"},
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected no error to occur: %v", err)
+	}
+	mountDirPath := ns.ephemeralMountDirPath(volumeID)
+	connAuth, err := fsutil.ReadFile(path.Join(mountDirPath, "connAuthFile"))
+	if err != nil || string(connAuth) != "secret" {
+		t.Fatalf("expected connAuthFile with the secret from the request, got %q and error: %v", connAuth, err)
+	}
+
+	// Simulate a node reboot, after which the BeeGFS file system is no longer mounted and its client configuration
+	// files are gone.
+	if err := mounter.Unmount(path.Join(mountDirPath, "mount")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"beegfs-client.conf", "connInterfacesFile", "connAuthFile"} {
+		if err := fs.Remove(path.Join(mountDirPath, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   volumeID,
+		TargetPath: targetPath,
+	})
+	if err != nil {
+		t.Fatalf("expected no error to occur: %v", err)
+	}
+
+	if len(mounter.clientConfs) != 2 {
+		t.Fatalf("expected the file system to be mounted twice, got %d mounts", len(mounter.clientConfs))
+	}
+	for i, action := range []string{"publish", "unpublish"} {
+		for _, want := range []string{"connUseRDMA = false\n", "tuneFileCacheType = native\n"} {
+			if !strings.Contains(mounter.clientConfs[i], want) {
+				t.Errorf("expected beegfs-client.conf on %s to contain %q, got:\n%s", action, want,
+					mounter.clientConfs[i])
+			}
+		}
+		if mounter.connInterfaces[i] != "eth0\n" {
+			t.Errorf("expected connInterfacesFile on %s to contain eth0, got: %q", action, mounter.connInterfaces[i])
+		}
+	}
+	if _, err := fs.Stat(mountDirPath); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed, got: %v", mountDirPath, err)
+	}
+}
+
+func TestNodeGetInfo(t *testing.T) {
+	tests := map[string]struct {
+		nodeInfo nodeInfoConfig
+		want     *csi.NodeGetInfoResponse
+	}{
+		"no node info": {
+			want: &csi.NodeGetInfoResponse{NodeId: "testnode", AccessibleTopology: &csi.Topology{Segments: map[string]string{}}},
+		},
+		"max volumes and topology": {
+			nodeInfo: nodeInfoConfig{
+				MaxVolumesPerNode: 10,
+				TopologySegments:  map[string]string{"beegfs.csi.netapp.com/fabric": "ib"},
+			},
+			want: &csi.NodeGetInfoResponse{
+				NodeId:             "testnode",
+				MaxVolumesPerNode:  10,
+				AccessibleTopology: &csi.Topology{Segments: map[string]string{"beegfs.csi.netapp.com/fabric": "ib"}},
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ns := NewNodeServer("testnode", newPluginConfigStore(pluginConfig{NodeInfo: tc.nodeInfo}),
+				"/etc/beegfs/beegfs-client.conf", "/csDataDir/ephemeral")
+			got, err := ns.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected response: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"bufio"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// readinessCheckInterval is how often the readiness checks Probe reports on are run.
+const readinessCheckInterval = 30 * time.Second
+
+// procFilesystemsPath is a variable so that tests can supply their own list of file system types.
+var procFilesystemsPath = "/proc/filesystems"
+
+// readinessCheck is a single check of a dependency the driver requires. check returns an error explaining why the
+// driver is not ready if the dependency is not satisfied.
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// readinessProber periodically runs a set of readiness checks and caches the results so that Probe stays cheap.
+type readinessProber struct {
+	checks   []readinessCheck
+	interval time.Duration
+
+	mutex   sync.RWMutex
+	checked bool  // true after all checks have run at least once
+	err     error // first failure from the most recent run of all checks
+}
+
+func newReadinessProber(interval time.Duration, checks []readinessCheck) *readinessProber {
+	return &readinessProber{
+		checks:   checks,
+		interval: interval,
+	}
+}
+
+// run runs all checks immediately and then once every interval. It never returns.
+func (p *readinessProber) run() {
+	for {
+		p.checkOnce()
+		time.Sleep(p.interval)
+	}
+}
+
+// checkOnce runs all checks and caches the result. It logs whenever the driver's readiness changes.
+func (p *readinessProber) checkOnce() {
+	var err error
+	for _, c := range p.checks {
+		if checkErr := c.check(); checkErr != nil {
+			err = errors.WithMessagef(checkErr, "readiness check %s failed", c.name)
+			break
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	switch {
+	case err != nil && (p.err == nil || p.err.Error() != err.Error()):
+		klog.ErrorS(err, "Driver is not ready")
+	case err == nil && (p.err != nil || !p.checked):
+		klog.InfoS("Driver is ready")
+	default:
+		klog.V(LogVerbose).InfoS("Driver readiness is unchanged")
+	}
+	p.checked = true
+	p.err = err
+}
+
+// status returns the cached result of the most recent run of all checks. ready is false and err is nil if the checks
+// have not run yet.
+func (p *readinessProber) status() (ready bool, err error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if !p.checked {
+		return false, nil
+	}
+	if p.err != nil {
+		return false, p.err
+	}
+	return true, nil
+}
+
+// readinessChecks returns the checks the driver must pass before Probe reports it ready.
+func (b *beegfs) readinessChecks() []readinessCheck {
+	return []readinessCheck{
+		{
+			name:  "beegfs-ctl",
+			check: func() error { return b.cs.ctlExec.checkAvailable() },
+		},
+		{
+			name:  "beegfs file system type",
+			check: checkBeegfsFilesystemType,
+		},
+		{
+			name:  "csDataDir",
+			check: func() error { return checkDirWritable(b.csDataDir) },
+		},
+		{
+			name:  "client conf template",
+			check: func() error { return checkFileReadable(b.clientConfTemplatePath) },
+		},
+		{
+			// The configuration is validated against the template at startup and reload, but the template may change.
+			name:  "client configuration",
+			check: func() error { return validateClientConf(b.configStore.load(), b.clientConfTemplatePath) },
+		},
+	}
+}
+
+// checkBeegfsFilesystemType returns an error if the kernel does not support the beegfs file system type (e.g. because
+// the BeeGFS client kernel module is not loaded).
+func checkBeegfsFilesystemType() error {
+	file, err := fs.Open(procFilesystemsPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Each line is like "nodev	beegfs" or "	ext4".
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[len(fields)-1] == "beegfs" {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.Errorf("beegfs file system type not found in %s (is the BeeGFS client kernel module loaded?)",
+		procFilesystemsPath)
+}
+
+// checkDirWritable returns an error if a file cannot be created in dirPath.
+func checkDirWritable(dirPath string) error {
+	file, err := fsutil.TempFile(dirPath, ".readiness-")
+	if err != nil {
+		return errors.Wrapf(err, "%s is not writable", dirPath)
+	}
+	file.Close()
+	if err := fs.Remove(file.Name()); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// checkFileReadable returns an error if the file at filePath cannot be read.
+func checkFileReadable(filePath string) error {
+	if _, err := fsutil.ReadFile(filePath); err != nil {
+		return errors.Wrapf(err, "%s is not readable", filePath)
+	}
+	return nil
+}
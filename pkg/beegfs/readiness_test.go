@@ -0,0 +1,140 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"golang.org/x/net/context"
+)
+
+func TestProbe(t *testing.T) {
+	checkErr := errors.New("not ready")
+	tests := map[string]struct {
+		prober    *readinessProber
+		runChecks bool
+		wantReady bool
+	}{
+		"no prober": {
+			prober:    nil,
+			wantReady: false, // Ready is unset.
+		},
+		"checks not run yet": {
+			prober: newReadinessProber(readinessCheckInterval, []readinessCheck{
+				{name: "pass", check: func() error { return nil }},
+			}),
+			wantReady: false,
+		},
+		"checks pass": {
+			prober: newReadinessProber(readinessCheckInterval, []readinessCheck{
+				{name: "pass", check: func() error { return nil }},
+			}),
+			runChecks: true,
+			wantReady: true,
+		},
+		"check fails": {
+			prober: newReadinessProber(readinessCheckInterval, []readinessCheck{
+				{name: "pass", check: func() error { return nil }},
+				{name: "fail", check: func() error { return checkErr }},
+			}),
+			runChecks: true,
+			wantReady: false,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ids := NewIdentityServer("testDriver", "v0.1")
+			ids.prober = tc.prober
+			if tc.runChecks {
+				ids.prober.checkOnce()
+			}
+			resp, err := ids.Probe(context.Background(), &csi.ProbeRequest{})
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if tc.prober != nil && resp.GetReady() == nil {
+				t.Fatal("expected ready to be set")
+			}
+			if resp.GetReady().GetValue() != tc.wantReady {
+				t.Fatalf("expected ready %t, got: %v", tc.wantReady, resp.GetReady())
+			}
+		})
+	}
+}
+
+func TestReadinessProberRecovers(t *testing.T) {
+	var checkErr error = errors.New("not ready")
+	prober := newReadinessProber(readinessCheckInterval, []readinessCheck{
+		{name: "flaky", check: func() error { return checkErr }},
+	})
+	prober.checkOnce()
+	if ready, err := prober.status(); ready || err == nil {
+		t.Fatalf("expected not ready with error, got ready %t and error %v", ready, err)
+	}
+	checkErr = nil
+	prober.checkOnce()
+	if ready, err := prober.status(); !ready || err != nil {
+		t.Fatalf("expected ready without error, got ready %t and error %v", ready, err)
+	}
+}
+
+func TestCheckBeegfsFilesystemType(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	tests := map[string]struct {
+		filesystems string
+		wantErr     bool
+	}{
+		"beegfs loaded": {
+			filesystems: "nodev\tsysfs\nnodev\tproc\n\txfs\nnodev\tbeegfs\n",
+			wantErr:     false,
+		},
+		"beegfs not loaded": {
+			filesystems: "nodev\tsysfs\nnodev\tproc\n\txfs\n",
+			wantErr:     true,
+		},
+		"similar name": {
+			filesystems: "nodev\tbeegfs_old\n",
+			wantErr:     true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := fsutil.WriteFile(procFilesystemsPath, []byte(tc.filesystems), 0444); err != nil {
+				t.Fatal(err)
+			}
+			err := checkBeegfsFilesystemType()
+			if tc.wantErr && err == nil {
+				t.Fatal("expected error, got none")
+			} else if !tc.wantErr && err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckDirWritable(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	if err := fs.MkdirAll("/csDataDir", 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkDirWritable("/csDataDir"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if files, _ := fsutil.ReadDir("/csDataDir"); len(files) != 0 {
+		t.Fatalf("expected no files left in /csDataDir, got: %v", files)
+	}
+
+	fs = afero.NewReadOnlyFs(fs)
+	fsutil = afero.Afero{Fs: fs}
+	if err := checkDirWritable("/csDataDir"); err == nil {
+		t.Fatal("expected error for read-only directory, got none")
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// redactedValue replaces sensitive values wherever configuration, client configuration files, or command output are
+// logged or returned in errors.
+const redactedValue = "<redacted>"
+
+// sensitiveBeegfsClientConfKeys contains the beegfs-client.conf parameters whose values are sensitive.
+var sensitiveBeegfsClientConfKeys = []string{
+	"connAuthFile",
+}
+
+// sensitiveTextRegex matches a sensitive beegfs-client.conf parameter and its value in text (e.g. a rendered
+// beegfs-client.conf file or beegfs-ctl output) like "connAuthFile = value" or "connAuthFile: value".
+var sensitiveTextRegex = regexp.MustCompile(`\b(` + strings.Join(sensitiveBeegfsClientConfKeys, "|") +
+	`)([ \t]*[=:][ \t]*)[^\s]+`)
+
+// redactText returns text with the values of sensitive beegfs-client.conf parameters replaced by redactedValue.
+func redactText(text string) string {
+	return sensitiveTextRegex.ReplaceAllString(text, "${1}${2}"+redactedValue)
+}
+
+// redactBeegfsClientConfValue returns redactedValue if key is a sensitive beegfs-client.conf parameter and value
+// otherwise.
+func redactBeegfsClientConfValue(key, value string) string {
+	if containsString(sensitiveBeegfsClientConfKeys, key) {
+		return redactedValue
+	}
+	return value
+}
+
+// redacted returns a copy of c that is safe to log. Non-empty string fields tagged `sensitive:"true"` are replaced by
+// redactedValue, sensitive parameters are masked in other string fields (e.g. an inline beegfs-client.conf template),
+// and the values of sensitive parameters in BeegfsClientConf are replaced by redactedValue. c is not modified.
+func (c beegfsConfig) redacted() beegfsConfig {
+	value := reflect.ValueOf(&c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if field.Kind() != reflect.String || field.String() == "" {
+			continue
+		}
+		if value.Type().Field(i).Tag.Get("sensitive") == "true" {
+			field.SetString(redactedValue)
+		} else {
+			field.SetString(redactText(field.String()))
+		}
+	}
+	if c.BeegfsClientConf != nil {
+		beegfsClientConf := make(map[string]string, len(c.BeegfsClientConf))
+		for k, v := range c.BeegfsClientConf {
+			beegfsClientConf[k] = redactBeegfsClientConfValue(k, v)
+		}
+		c.BeegfsClientConf = beegfsClientConf
+	}
+	return c
+}
+
+// redactFileSystemSpecificConfigs returns a copy of configs in which every beegfsConfig is redacted.
+func redactFileSystemSpecificConfigs(configs []fileSystemSpecificConfig) []fileSystemSpecificConfig {
+	if configs == nil {
+		return nil
+	}
+	redactedConfigs := make([]fileSystemSpecificConfig, len(configs))
+	for i, config := range configs {
+		config.Config = config.Config.redacted()
+		redactedConfigs[i] = config
+	}
+	return redactedConfigs
+}
+
+// redacted returns a copy of plConfig in which every beegfsConfig is redacted. plConfig is not modified.
+func (plConfig pluginConfig) redacted() pluginConfig {
+	plConfig.DefaultConfig = plConfig.DefaultConfig.redacted()
+	plConfig.FileSystemSpecificConfigs = redactFileSystemSpecificConfigs(plConfig.FileSystemSpecificConfigs)
+	if plConfig.FileSystems != nil {
+		fileSystems := make([]namedFileSystem, len(plConfig.FileSystems))
+		for i, namedFS := range plConfig.FileSystems {
+			namedFS.Config = namedFS.Config.redacted()
+			fileSystems[i] = namedFS
+		}
+		plConfig.FileSystems = fileSystems
+	}
+	return plConfig
+}
+
+// redacted returns a copy of rawConfig in which every beegfsConfig is redacted. rawConfig is not modified.
+func (rawConfig pluginConfigFromFile) redacted() pluginConfigFromFile {
+	rawConfig.pluginConfig = rawConfig.pluginConfig.redacted()
+	if rawConfig.NodeSpecificConfigs != nil {
+		nodeConfigs := make([]nodeSpecificConfig, len(rawConfig.NodeSpecificConfigs))
+		for i, nodeConfig := range rawConfig.NodeSpecificConfigs {
+			nodeConfig.DefaultConfig = nodeConfig.DefaultConfig.redacted()
+			nodeConfig.FileSystemSpecificConfigs = redactFileSystemSpecificConfigs(nodeConfig.FileSystemSpecificConfigs)
+			nodeConfigs[i] = nodeConfig
+		}
+		rawConfig.NodeSpecificConfigs = nodeConfigs
+	}
+	return rawConfig
+}
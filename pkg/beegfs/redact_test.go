@@ -0,0 +1,183 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+// testSecret is a stand-in for a sensitive value. Tests assert that it never appears in log output or errors.
+const testSecret = "/secrets/s3cr3t-connauth"
+
+// captureLogs directs klog output at all verbosities to a buffer until the returned function is called.
+func captureLogs(t *testing.T) (*bytes.Buffer, func()) {
+	flags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flags)
+	if err := flags.Set("v", "10"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	klog.SetLogger(newJSONLogger(&buf))
+	return &buf, func() {
+		klog.SetLogger(nil)
+		_ = flags.Set("v", "0")
+	}
+}
+
+func TestRedactText(t *testing.T) {
+	tests := map[string]struct {
+		text, want string
+	}{
+		"client conf": {
+			text: "sysMgmtdHost = 127.0.0.1\nconnAuthFile = /a/b\n",
+			want: "sysMgmtdHost = 127.0.0.1\nconnAuthFile = <redacted>\n",
+		},
+		"aligned":       {"connAuthFile          = /a/b", "connAuthFile          = <redacted>"},
+		"colon":         {"connAuthFile: /a/b", "connAuthFile: <redacted>"},
+		"empty value":   {"connAuthFile =\nlogLevel = 3\n", "connAuthFile =\nlogLevel = 3\n"},
+		"not sensitive": {"connInterfacesFile = /a/b", "connInterfacesFile = /a/b"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := redactText(tc.text); got != tc.want {
+				t.Fatalf("expected %q, got: %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestBeegfsConfigRedacted(t *testing.T) {
+	config := beegfsConfig{
+		ConnInterfaces:     []string{"ib0"},
+		BeegfsClientConf:   map[string]string{"connAuthFile": testSecret, "logLevel": "3"},
+		ClientConfTemplate: "sysMgmtdHost =\nconnAuthFile = " + testSecret + "\n",
+		ConnAuthFile:       testSecret,
+	}
+	got := config.redacted()
+	if got.ConnAuthFile != redactedValue || got.BeegfsClientConf["connAuthFile"] != redactedValue {
+		t.Errorf("expected sensitive values to be redacted, got: %+v", got)
+	}
+	if strings.Contains(got.ClientConfTemplate, testSecret) {
+		t.Errorf("expected connAuthFile to be redacted in template, got: %s", got.ClientConfTemplate)
+	}
+	if got.BeegfsClientConf["logLevel"] != "3" || got.ConnInterfaces[0] != "ib0" {
+		t.Errorf("expected other values to be preserved, got: %+v", got)
+	}
+	if config.ConnAuthFile != testSecret || config.BeegfsClientConf["connAuthFile"] != testSecret {
+		t.Errorf("expected original configuration to be unmodified, got: %+v", config)
+	}
+}
+
+// TestParseConfigRedactsLogs verifies that parsing a configuration file never logs sensitive values, even at the
+// highest verbosity.
+func TestParseConfigRedactsLogs(t *testing.T) {
+	buf, restore := captureLogs(t)
+	defer restore()
+
+	rawConfig := `config:
+  connAuthFile: ` + testSecret + `
+  beegfsClientConf:
+    connAuthFile: ` + testSecret + `
+fileSystems:
+  - name: scratch
+    sysMgmtdHost: 127.0.0.1
+    config:
+      connAuthFile: ` + testSecret + `
+nodeSpecificConfigs:
+  - nodeList:
+      - testnode
+    config:
+      clientConfTemplate: |
+        connAuthFile = ` + testSecret + `
+`
+	config, err := parseConfig([]byte(rawConfig), "testnode", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DefaultConfig.ConnAuthFile != testSecret {
+		t.Fatalf("expected parsed configuration to contain connAuthFile, got: %+v", config.DefaultConfig)
+	}
+	if !strings.Contains(buf.String(), "Applying configuration") {
+		t.Fatalf("expected configuration to be logged, got: %s", buf.String())
+	}
+	if strings.Contains(buf.String(), testSecret) {
+		t.Fatalf("expected no sensitive values in log output, got: %s", buf.String())
+	}
+}
+
+// TestExecuteRedactsOutput verifies that sensitive values in beegfs-ctl output are redacted in log output and errors.
+func TestExecuteRedactsOutput(t *testing.T) {
+	binDir, err := ioutil.TempDir("", "beegfs-ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(binDir)
+	script := "#!/bin/sh\necho connAuthFile = " + testSecret + "\necho connAuthFile: " + testSecret + " >&2\n" +
+		"case \"$*\" in *exists*) echo exists already >&2 ;; esac\nexit 1\n"
+	if err := ioutil.WriteFile(path.Join(binDir, "beegfs-ctl"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	if err := os.Setenv("PATH", binDir+string(os.PathListSeparator)+oldPath); err != nil {
+		t.Fatal(err)
+	}
+	buf, restore := captureLogs(t)
+	defer restore()
+
+	for _, args := range [][]string{{"--getentryinfo", "/"}, {"--createdir", "/exists"}} {
+		_, err := (&beegfsCtlExecutor{}).execute(context.Background(), "/testvol/beegfs-client.conf", args)
+		if err == nil {
+			t.Fatal("expected an error to occur")
+		}
+		if strings.Contains(err.Error(), testSecret) {
+			t.Errorf("expected no sensitive values in error, got: %v", err)
+		}
+	}
+	if !strings.Contains(buf.String(), "Command finished") {
+		t.Fatalf("expected command output to be logged, got: %s", buf.String())
+	}
+	if strings.Contains(buf.String(), testSecret) {
+		t.Fatalf("expected no sensitive values in log output, got: %s", buf.String())
+	}
+}
+
+// TestCheckConfigRedacts verifies that config check output does not contain sensitive values.
+func TestCheckConfigRedacts(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	const (
+		confTemplatePath = "/etc/beegfs/beegfs-client.conf"
+		configPath       = "/config/csi-beegfs-config.yaml"
+	)
+	if err := fsutil.WriteFile(confTemplatePath, []byte("sysMgmtdHost =\nconnClientPortUDP =\nconnAuthFile =\n"),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsutil.WriteFile(configPath, []byte("config:\n  connAuthFile: "+testSecret+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := CheckConfig(&out, configPath, "testnode", "127.0.0.1", confTemplatePath, nil, false); err != nil {
+		t.Fatalf("expected no error to occur: %v", err)
+	}
+	if !strings.Contains(out.String(), "connAuthFile: "+redactedValue) {
+		t.Errorf("expected redacted connAuthFile in output, got:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), testSecret) {
+		t.Errorf("expected no sensitive values in output, got:\n%s", out.String())
+	}
+}
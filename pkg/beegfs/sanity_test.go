@@ -12,10 +12,13 @@ import (
 	"testing"
 
 	"github.com/kubernetes-csi/csi-test/pkg/sanity"
+	"github.com/spf13/afero"
 	"k8s.io/utils/mount"
 )
 
 func TestSanity(t *testing.T) {
+	fs = afero.NewOsFs()
+	fsutil = afero.Afero{Fs: fs}
 	sanityDir, err := ioutil.TempDir("", "driver-sanity")
 	if err != nil {
 		t.Fatal(err)
@@ -29,7 +32,7 @@ func TestSanity(t *testing.T) {
 	}
 
 	// Create and run the driver
-	driver, err := NewBeegfsDriver("", csDataDirPath, "testDriver", endpoint, "testID", clientConfTemplatePath, "v0.1")
+	driver, err := NewBeegfsDriver("", csDataDirPath, CsDataDirSweepEnabled, "testDriver", endpoint, "testID", clientConfTemplatePath, "v0.1", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -37,7 +40,11 @@ func TestSanity(t *testing.T) {
 	driver.cs.mounter = mount.NewFakeMounter(mps)
 	driver.ns.mounter = mount.NewFakeMounter(mps)
 	driver.cs.ctlExec = &fakeBeegfsCtlExecutor{}
-	go driver.Run()
+	driver.ns.ctlExec = &fakeBeegfsCtlExecutor{}
+	driver.ids.prober = nil // The test environment does not have the BeeGFS client kernel module loaded.
+	stop := make(chan struct{})
+	defer close(stop)
+	go driver.Run(stop)
 
 	// Setup paths for mounting and staging
 	mntDir := path.Join(sanityDir, "mnt")
@@ -28,15 +28,18 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/golang/glog"
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
 )
 
 // Traditionally gRPC service handlers should return an error created by the
@@ -92,13 +95,29 @@ type nonBlockingGRPCServer struct {
 	server *grpc.Server
 }
 
+// Start registers the services and serves them on endpoint in the background. The server is created before Start
+// returns, so Stop and ForceStop may be called as soon as Start returns.
 func (s *nonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+	opts := []grpc.ServerOption{
+		// otelgrpc starts a span for each RPC (continuing any trace propagated in gRPC metadata) before logGRPC and
+		// the service handler run. It does nothing unless InitTracing has configured an exporter.
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), logGRPC),
+	}
+	s.server = grpc.NewServer(opts...)
 
-	s.wg.Add(1)
+	if ids != nil {
+		csi.RegisterIdentityServer(s.server, ids)
+	}
+	if cs != nil {
+		csi.RegisterControllerServer(s.server, cs)
+	}
+	if ns != nil {
+		csi.RegisterNodeServer(s.server, ns)
+	}
 
-	go s.serve(endpoint, ids, cs, ns)
+	s.wg.Add(1)
 
-	return
+	go s.serve(endpoint)
 }
 
 func (s *nonBlockingGRPCServer) Wait() {
@@ -113,48 +132,33 @@ func (s *nonBlockingGRPCServer) ForceStop() {
 	s.server.Stop()
 }
 
-func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+func (s *nonBlockingGRPCServer) serve(endpoint string) {
+	defer s.wg.Done()
 
 	proto, addr, err := parseEndpoint(endpoint)
 	if err != nil {
-		glog.Fatal(err.Error())
+		klog.Fatal(err.Error())
 	}
 
 	if proto == "unix" {
 		addr = "/" + addr
 		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) { //nolint: vetshadow
-			glog.Fatalf("Failed to remove %s, error: %s", addr, err.Error())
+			klog.Fatalf("Failed to remove %s, error: %s", addr, err.Error())
 		}
 	}
 
 	listener, err := net.Listen(proto, addr)
 	if err != nil {
-		glog.Fatalf("Failed to listen: %v", err)
-	}
-
-	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logGRPC),
+		klog.Fatalf("Failed to listen: %v", err)
 	}
-	server := grpc.NewServer(opts...)
-	s.server = server
 
-	if ids != nil {
-		csi.RegisterIdentityServer(server, ids)
-	}
-	if cs != nil {
-		csi.RegisterControllerServer(server, cs)
-	}
-	if ns != nil {
-		csi.RegisterNodeServer(server, ns)
-	}
-
-	glog.Infof("Listening for connections on address: %#v", listener.Addr())
+	klog.InfoS("Listening for connections", "address", listener.Addr().String())
 
-	if err = server.Serve(listener); err != nil {
+	if err = s.server.Serve(listener); err != nil {
 		if err == grpc.ErrServerStopped {
-			glog.Info(err.Error())
+			klog.Info(err.Error())
 		} else {
-			glog.Fatal(err.Error())
+			klog.Fatal(err.Error())
 		}
 	}
 }
@@ -169,6 +173,9 @@ func parseEndpoint(ep string) (string, string, error) {
 	return "", "", errors.Errorf("invalid endpoint: %v", ep)
 }
 
+// logGRPC logs each RPC and its outcome. It generates a request ID for the RPC, adds it to the context the service
+// handler receives (so that newLogger includes it in every log line written while handling the RPC), and returns it
+// to the caller in the x-request-id response header.
 func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	logLevel := LogDebug
 	// These GRPC methods are called very frequently. Filter them out so they only appear at higher log levels.
@@ -176,19 +183,31 @@ func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h
 		logLevel = LogVerbose
 	}
 
-	glog.V(logLevel).Infof("GRPC call: %s with request: %+v", info.FullMethod, protosanitizer.StripSecrets(req))
+	requestID := newRequestID()
+	ctx = withRequestID(ctx, requestID)
+	// SetHeader only fails if ctx does not belong to a gRPC stream (e.g. in unit tests).
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadataKey, requestID))
+	log := newLogger(ctx).WithValues("method", info.FullMethod)
+	if r, ok := req.(interface{ GetVolumeId() string }); ok && r.GetVolumeId() != "" {
+		log = log.WithValues("volume_id", r.GetVolumeId())
+	}
+
+	log.V(logLevel).Info("GRPC call", "request", protosanitizer.StripSecrets(req))
+	start := time.Now()
 	resp, err := handler(ctx, req)
 	if err != nil {
-		glog.Errorf("GRPC error: %+v for call: %s with request: %+v", err, info.FullMethod,
-			protosanitizer.StripSecrets(req))
+		// %+v includes the stack trace of errors created by the package "github.com/pkg/errors".
+		log.Error(err, "GRPC error", "request", protosanitizer.StripSecrets(req), "duration", time.Since(start),
+			"details", fmt.Sprintf("%+v", err))
 		var grpcErr grpcError
 		if errors.As(err, &grpcErr) {
 			// only forward statusErr
 			err = grpcErr.GetStatusErr()
 		}
 	} else {
-		glog.V(logLevel).Infof("GRPC response: %+v for call: %s with request: %+v",
-			protosanitizer.StripSecrets(resp), info.FullMethod, protosanitizer.StripSecrets(req))
+		log.V(logLevel).Info("GRPC response", "response", protosanitizer.StripSecrets(resp),
+			"duration", time.Since(start))
 	}
+	observeRPC(info.FullMethod, status.Code(err).String(), start)
 	return resp, err
 }
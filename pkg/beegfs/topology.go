@@ -0,0 +1,64 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// volumeTopology returns the topologies from which a volume on a file system with config is accessible (see
+// beegfsConfig.AccessibleTopology) or nil if the volume is accessible from every node.
+func volumeTopology(config beegfsConfig) []*csi.Topology {
+	if len(config.AccessibleTopology) == 0 {
+		return nil
+	}
+	topologies := make([]*csi.Topology, 0, len(config.AccessibleTopology))
+	for _, segments := range config.AccessibleTopology {
+		topologySegments := make(map[string]string, len(segments))
+		for k, v := range segments {
+			topologySegments[k] = v
+		}
+		topologies = append(topologies, &csi.Topology{Segments: topologySegments})
+	}
+	return topologies
+}
+
+// isAccessibleFrom returns true if a volume accessible from volTopologies (see volumeTopology) satisfies
+// requirements. A BeeGFS volume is accessible from all of its topologies at once, so it satisfies requirements if
+// any requisite topology matches any of volTopologies. Preferred topologies are only considered if requirements has no
+// requisite topologies.
+func isAccessibleFrom(volTopologies []*csi.Topology, requirements *csi.TopologyRequirement) bool {
+	if len(volTopologies) == 0 {
+		return true
+	}
+	candidates := requirements.GetRequisite()
+	if len(candidates) == 0 {
+		candidates = requirements.GetPreferred()
+	}
+	if len(candidates) == 0 {
+		return true
+	}
+	for _, candidate := range candidates {
+		for _, volTopology := range volTopologies {
+			if topologyMatches(volTopology, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// topologyMatches returns true if every segment of volTopology is also a segment of nodeTopology. nodeTopology may
+// contain additional segments (e.g. the CO aggregates the segments of all nodes into requisite topologies).
+func topologyMatches(volTopology, nodeTopology *csi.Topology) bool {
+	nodeSegments := nodeTopology.GetSegments()
+	for k, v := range volTopology.GetSegments() {
+		if nodeValue, ok := nodeSegments[k]; !ok || nodeValue != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsAccessibleFrom(t *testing.T) {
+	ib := &csi.Topology{Segments: map[string]string{"beegfs.csi.netapp.com/fabric": "ib"}}
+	tcp := &csi.Topology{Segments: map[string]string{"beegfs.csi.netapp.com/fabric": "tcp"}}
+	ibZoneA := &csi.Topology{Segments: map[string]string{
+		"beegfs.csi.netapp.com/fabric": "ib",
+		"topology.kubernetes.io/zone":  "zone-a",
+	}}
+	tests := map[string]struct {
+		volTopologies        []*csi.Topology
+		requisite, preferred []*csi.Topology
+		want                 bool
+	}{
+		"no volume topology":          {requisite: []*csi.Topology{tcp}, want: true},
+		"no requirements":             {volTopologies: []*csi.Topology{ib}, want: true},
+		"requisite matches":           {volTopologies: []*csi.Topology{ib}, requisite: []*csi.Topology{tcp, ib}, want: true},
+		"requisite has more segments": {volTopologies: []*csi.Topology{ib}, requisite: []*csi.Topology{ibZoneA}, want: true},
+		"requisite has less segments": {volTopologies: []*csi.Topology{ibZoneA}, requisite: []*csi.Topology{ib}},
+		"requisite differs":           {volTopologies: []*csi.Topology{ib}, requisite: []*csi.Topology{tcp}},
+		"any volume topology":         {volTopologies: []*csi.Topology{tcp, ib}, requisite: []*csi.Topology{ib}, want: true},
+		"only preferred":              {volTopologies: []*csi.Topology{ib}, preferred: []*csi.Topology{tcp}},
+		"requisite before preferred": {
+			volTopologies: []*csi.Topology{ib},
+			requisite:     []*csi.Topology{ib},
+			preferred:     []*csi.Topology{tcp},
+			want:          true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var requirements *csi.TopologyRequirement
+			if tc.requisite != nil || tc.preferred != nil {
+				requirements = &csi.TopologyRequirement{Requisite: tc.requisite, Preferred: tc.preferred}
+			}
+			if got := isAccessibleFrom(tc.volTopologies, requirements); got != tc.want {
+				t.Fatalf("expected %t, got: %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeTopology(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	const confTemplatePath = "/etc/beegfs/beegfs-client.conf"
+	if err := fsutil.WriteFile(confTemplatePath, []byte(TestWriteClientFilesTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ib := map[string]string{"beegfs.csi.netapp.com/fabric": "ib"}
+	config := pluginConfig{
+		FileSystemSpecificConfigs: []fileSystemSpecificConfig{
+			{SysMgmtdHost: "127.0.0.1", Config: beegfsConfig{AccessibleTopology: []map[string]string{ib}}},
+		},
+	}
+
+	tests := map[string]struct {
+		sysMgmtdHost string
+		requisite    map[string]string
+		want         []*csi.Topology
+		wantCode     codes.Code
+	}{
+		"reachable": {
+			sysMgmtdHost: "127.0.0.1",
+			requisite:    ib,
+			want:         []*csi.Topology{{Segments: ib}},
+		},
+		"unreachable": {
+			sysMgmtdHost: "127.0.0.1",
+			requisite:    map[string]string{"beegfs.csi.netapp.com/fabric": "tcp"},
+			wantCode:     codes.ResourceExhausted,
+		},
+		"no file system topology": {
+			sysMgmtdHost: "127.0.0.2",
+			requisite:    map[string]string{"beegfs.csi.netapp.com/fabric": "tcp"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cs := NewControllerServer("testnode", newPluginConfigStore(config), confTemplatePath, "/csDataDir")
+			cs.ctlExec = &fakeBeegfsCtlExecutor{}
+			resp, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "vol1",
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				}},
+				Parameters: map[string]string{sysMgmtdHostKey: tc.sysMgmtdHost, volDirBasePathKey: "/scratch"},
+				AccessibilityRequirements: &csi.TopologyRequirement{
+					Requisite: []*csi.Topology{{Segments: tc.requisite}},
+				},
+			})
+			if tc.wantCode != codes.OK {
+				if status.Code(errors.Cause(err)) != tc.wantCode {
+					t.Fatalf("expected error with code %s, got: %v", tc.wantCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(tc.want, resp.GetVolume().GetAccessibleTopology()) {
+				t.Fatalf("expected topology: %v, got: %v", tc.want, resp.GetVolume().GetAccessibleTopology())
+			}
+		})
+	}
+}
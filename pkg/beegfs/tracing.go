@@ -0,0 +1,108 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+// Valid values for the exporter parameter of InitTracing.
+const (
+	TracingExporterNone   = "none"   // do not export spans
+	TracingExporterOTLP   = "otlp"   // export spans to an OpenTelemetry collector using OTLP over gRPC
+	TracingExporterStdout = "stdout" // write spans to stdout as JSON
+	TracingExporterFile   = "file"   // append spans to a file as JSON
+)
+
+// tracerName identifies the spans the driver creates (as opposed to those created by instrumentation libraries).
+const tracerName = "github.com/netapp/beegfs-csi-driver/pkg/beegfs"
+
+// InitTracing configures the global OpenTelemetry tracer provider and propagator so that the driver creates a span for
+// each RPC (continuing any trace propagated in the RPC's gRPC metadata) and child spans for beegfs-ctl invocations,
+// mounts, unmounts, and client configuration file writes. otlpEndpoint is only used with TracingExporterOTLP and
+// filePath is only used with TracingExporterFile. InitTracing must be called before the driver runs. The returned
+// function flushes any remaining spans and must be called before the process exits.
+func InitTracing(exporter, otlpEndpoint, filePath string) (shutdown func(), err error) {
+	var spanExporter sdktrace.SpanExporter
+	var file *os.File
+	switch exporter {
+	case "", TracingExporterNone:
+		return func() {}, nil
+	case TracingExporterOTLP:
+		if spanExporter, err = otlp.NewExporter(context.Background(), otlpgrpc.NewDriver(
+			otlpgrpc.WithInsecure(), otlpgrpc.WithEndpoint(otlpEndpoint))); err != nil {
+			return nil, errors.Wrap(err, "failed to create OTLP exporter")
+		}
+	case TracingExporterStdout:
+		if spanExporter, err = stdout.NewExporter(stdout.WithWriter(os.Stdout), stdout.WithoutMetricExport()); err != nil {
+			return nil, errors.Wrap(err, "failed to create stdout exporter")
+		}
+	case TracingExporterFile:
+		if file, err = os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			return nil, errors.Wrap(err, "failed to open tracing file")
+		}
+		if spanExporter, err = stdout.NewExporter(stdout.WithWriter(file), stdout.WithoutMetricExport()); err != nil {
+			file.Close()
+			return nil, errors.Wrap(err, "failed to create file exporter")
+		}
+	default:
+		return nil, errors.Errorf("invalid tracing exporter %s", exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.ServiceNameKey.String("beegfs-csi-driver"),
+			semconv.ServiceVersionKey.String(vendorVersion),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{},
+		propagation.Baggage{}))
+	klog.InfoS("Exporting traces", "exporter", exporter)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			klog.ErrorS(err, "Failed to shut down tracing")
+		}
+		if file != nil {
+			file.Close()
+		}
+	}, nil
+}
+
+// startSpan starts a span that is a child of the span in ctx (if any). It is cheap to call when tracing is not
+// configured.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err (if it is not nil) on span and ends span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"k8s.io/utils/mount"
+)
+
+// setUpTestTracing configures the global tracer provider and propagator to record spans in memory. It returns a
+// function that restores the previous configuration.
+func setUpTestTracing() (*tracetest.InMemoryExporter, func()) {
+	exporter := tracetest.NewInMemoryExporter()
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return exporter, func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	}
+}
+
+func TestInitTracing(t *testing.T) {
+	tests := map[string]struct {
+		exporter string
+		wantErr  bool
+	}{
+		"none": {
+			exporter: TracingExporterNone,
+		},
+		"unset": {
+			exporter: "",
+		},
+		"invalid": {
+			exporter: "jaeger",
+			wantErr:  true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			shutdown, err := InitTracing(tc.exporter, "", "")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			shutdown()
+		})
+	}
+}
+
+func TestMountSpanIsChildOfRPCSpan(t *testing.T) {
+	fs = afero.NewOsFs() // mount.FakeMounter uses the real file system to check mount points
+	fsutil = afero.Afero{Fs: fs}
+	exporter, restore := setUpTestTracing()
+	defer restore()
+
+	mountDirPath, err := ioutil.TempDir("", "tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(mountDirPath)
+	vol := newBeegfsVolume(mountDirPath, "127.0.0.1", "/scratch/vol1", pluginConfig{}, nil)
+
+	ctx, rpcSpan := startSpan(context.Background(), "rpc")
+	if err := mountIfNecessary(ctx, vol, nil, mount.NewFakeMounter(nil)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	rpcSpan.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got: %d", len(spans))
+	}
+	if spans[0].Name != "mount" || spans[0].Parent.SpanID() != rpcSpan.SpanContext().SpanID() {
+		t.Fatalf("expected mount span with parent %s, got: %s with parent %s", rpcSpan.SpanContext().SpanID(),
+			spans[0].Name, spans[0].Parent.SpanID())
+	}
+}
+
+func TestRPCSpanContinuesIncomingTrace(t *testing.T) {
+	exporter, restore := setUpTestTracing()
+	defer restore()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, span := startSpan(ctx, "beegfs-ctl createdir")
+		span.End()
+		return nil, nil
+	}
+	if _, err := otelgrpc.UnaryServerInterceptor()(ctx, nil, info, handler); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got: %d", len(spans))
+	}
+	for _, span := range spans {
+		if span.SpanContext.TraceID().String() != traceID {
+			t.Fatalf("expected span %s to have trace ID %s, got: %s", span.Name, traceID,
+				span.SpanContext.TraceID())
+		}
+	}
+}
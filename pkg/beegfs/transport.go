@@ -0,0 +1,75 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	transportKey = "transport" // StorageClass parameter (and volume context key) that forces a transport
+
+	// Valid values for the transport parameter.
+	transportRDMA = "rdma" // only use RDMA capable connInterfaces and never restrict RDMA with connTcpOnlyFilter
+	transportTCP  = "tcp"  // never use RDMA
+)
+
+// sysClassNetPath is the directory in which Linux lists network interfaces. The node service runs in the host's
+// network namespace, so it lists the host's interfaces.
+const sysClassNetPath = "/sys/class/net"
+
+// getTransportFromParams returns the transport StorageClass parameters, a volume's context, or an ephemeral volume's
+// attributes request or an empty string if they do not request one.
+func getTransportFromParams(params map[string]string) (string, error) {
+	transport := params[transportKey]
+	switch transport {
+	case "", transportRDMA, transportTCP:
+		return transport, nil
+	default:
+		return "", errors.Errorf("invalid %s %s: must be %s or %s", transportKey, transport, transportRDMA,
+			transportTCP)
+	}
+}
+
+// applyTransport modifies vol's configuration so that the BeeGFS client only uses vol.transport. For RDMA, it restricts
+// connInterfaces to those that support RDMA and returns an error if the node has no such interface. applyTransport must
+// only be called by the node service, as only the node service's configuration describes the node's interfaces.
+func applyTransport(vol *beegfsVolume) error {
+	switch vol.transport {
+	case transportTCP:
+		vol.config.BeegfsClientConf["connUseRDMA"] = "false"
+	case transportRDMA:
+		var rdmaInterfaces []string
+		for _, iface := range vol.config.ConnInterfaces {
+			if isRDMAInterface(iface) {
+				rdmaInterfaces = append(rdmaInterfaces, iface)
+			}
+		}
+		if len(rdmaInterfaces) == 0 {
+			if len(vol.config.ConnInterfaces) == 0 {
+				return errors.Errorf("volume requires %s transport but no connInterfaces are configured for %s on "+
+					"this node", transportRDMA, vol.sysMgmtdHost)
+			}
+			return errors.Errorf("volume requires %s transport but none of the connInterfaces configured for %s on "+
+				"this node (%s) supports RDMA", transportRDMA, vol.sysMgmtdHost,
+				strings.Join(vol.config.ConnInterfaces, ", "))
+		}
+		vol.config.ConnInterfaces = rdmaInterfaces
+		vol.config.ConnTcpOnlyFilter = nil
+		vol.config.BeegfsClientConf["connUseRDMA"] = "true"
+	}
+	return nil
+}
+
+// isRDMAInterface returns true if the network interface iface is backed by an RDMA device (e.g. an InfiniBand or RoCE
+// adapter).
+func isRDMAInterface(iface string) bool {
+	_, err := fs.Stat(path.Join(sysClassNetPath, iface, "device", "infiniband"))
+	return err == nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestGetTransportFromParams(t *testing.T) {
+	tests := map[string]struct {
+		params  map[string]string
+		want    string
+		wantErr bool
+	}{
+		"none":    {params: map[string]string{}},
+		"rdma":    {params: map[string]string{transportKey: "rdma"}, want: transportRDMA},
+		"tcp":     {params: map[string]string{transportKey: "tcp"}, want: transportTCP},
+		"invalid": {params: map[string]string{transportKey: "RDMA"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := getTransportFromParams(tc.params)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error to occur for params: %v", tc.params)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected transport %s, got: %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestApplyTransport(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	for _, iface := range []string{"ib0", "ib1"} {
+		if err := fs.MkdirAll(path.Join(sysClassNetPath, iface, "device", "infiniband", "mlx5_0"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.MkdirAll(path.Join(sysClassNetPath, "eth0", "device"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		transport        string
+		connInterfaces   []string
+		wantInterfaces   []string
+		wantTcpOnly      []string
+		wantConnUseRDMA  string
+		wantErrSubstring string
+	}{
+		"any": {
+			connInterfaces: []string{"eth0", "ib0"},
+			wantInterfaces: []string{"eth0", "ib0"},
+			wantTcpOnly:    []string{"10.0.0.0/8"},
+		},
+		"tcp": {
+			transport:       transportTCP,
+			connInterfaces:  []string{"eth0", "ib0"},
+			wantInterfaces:  []string{"eth0", "ib0"},
+			wantTcpOnly:     []string{"10.0.0.0/8"},
+			wantConnUseRDMA: "false",
+		},
+		"rdma": {
+			transport:       transportRDMA,
+			connInterfaces:  []string{"eth0", "ib0", "ib1"},
+			wantInterfaces:  []string{"ib0", "ib1"},
+			wantConnUseRDMA: "true",
+		},
+		"rdma without RDMA interfaces": {
+			transport:        transportRDMA,
+			connInterfaces:   []string{"eth0", "eth1"},
+			wantErrSubstring: "none of the connInterfaces configured for 127.0.0.1 on this node (eth0, eth1)",
+		},
+		"rdma without interfaces": {
+			transport:        transportRDMA,
+			wantErrSubstring: "no connInterfaces are configured for 127.0.0.1",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := pluginConfig{DefaultConfig: beegfsConfig{
+				ConnInterfaces:    tc.connInterfaces,
+				ConnTcpOnlyFilter: []string{"10.0.0.0/8"},
+			}}
+			vol := newBeegfsVolume("/testvol", "127.0.0.1", "/scratch/vol1", config, nil)
+			vol.transport = tc.transport
+			err := applyTransport(&vol)
+			if tc.wantErrSubstring != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErrSubstring) {
+					t.Fatalf("expected error containing %q, got: %v", tc.wantErrSubstring, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+			if !reflect.DeepEqual(tc.wantInterfaces, vol.config.ConnInterfaces) {
+				t.Fatalf("expected connInterfaces: %v, got: %v", tc.wantInterfaces, vol.config.ConnInterfaces)
+			}
+			if !reflect.DeepEqual(tc.wantTcpOnly, vol.config.ConnTcpOnlyFilter) {
+				t.Fatalf("expected connTcpOnlyFilter: %v, got: %v", tc.wantTcpOnly, vol.config.ConnTcpOnlyFilter)
+			}
+			if got := vol.config.BeegfsClientConf["connUseRDMA"]; got != tc.wantConnUseRDMA {
+				t.Fatalf("expected connUseRDMA %q, got: %q", tc.wantConnUseRDMA, got)
+			}
+		})
+	}
+}
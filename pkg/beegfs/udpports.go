@@ -0,0 +1,268 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// udpPortStateFileName is the name of the file in csDataDir in which a udpPortAllocator persists assignments.
+	udpPortStateFileName = "udp-ports.json"
+	// maxEphemeralPortAttempts is the number of ephemeral ports a udpPortAllocator tries before it gives up when no
+	// connClientPortUDPRange is configured.
+	maxEphemeralPortAttempts = 10
+	// maxMountAttempts is the number of times the node service tries to mount a BeeGFS file system when its
+	// connClientPortUDP turns out to be bound by another process (see nodeServer.writeClientFilesAndMount).
+	maxMountAttempts = 3
+)
+
+// udpPortAllocator selects connClientPortUDP for BeeGFS mounts. Each mountDirPath is assigned its own port and the
+// assignments are persisted in a state file so that a restarted driver does not hand out a port a BeeGFS mount it
+// staged earlier is still using. Assignments for a mountDirPath that no longer exists are dropped automatically. The
+// state file is locked while it is in use, so the controller and node services can share a csDataDir.
+type udpPortAllocator struct {
+	statePath  string
+	mutex      sync.Mutex          // serializes access to the state file within this process
+	isPortFree func(port int) bool // returns true if no other process has port bound; replaced in tests
+}
+
+// newUDPPortAllocator returns a udpPortAllocator that persists assignments in csDataDir.
+func newUDPPortAllocator(csDataDir string) *udpPortAllocator {
+	return &udpPortAllocator{
+		statePath:  path.Join(csDataDir, udpPortStateFileName),
+		isPortFree: isPortFreeUDP,
+	}
+}
+
+// portRangeExhaustedError indicates that every port in a connClientPortUDPRange is assigned or in use.
+type portRangeExhaustedError struct {
+	portRange string
+}
+
+func (err portRangeExhaustedError) Error() string {
+	return fmt.Sprintf("no free connClientPortUDP in connClientPortUDPRange %s", err.portRange)
+}
+
+// allocate returns the connClientPortUDP for mountDirPath. If mountDirPath already has a port in portRange (e.g.
+// because NodeStageVolume is called again for a staged volume), allocate returns the same port. Otherwise, it assigns
+// the lowest port in portRange that is neither assigned to another mountDirPath nor bound by another process. If
+// portRange is empty, allocate assigns an ephemeral port instead (see getEphemeralPortUDP). allocate returns a
+// portRangeExhaustedError if no port in portRange is free.
+func (a *udpPortAllocator) allocate(mountDirPath, portRange string) (int, error) {
+	first, last, err := parsePortRange(portRange)
+	if err != nil {
+		return 0, err
+	}
+	unlock, err := a.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	assignments, err := a.load()
+	if err != nil {
+		return 0, err
+	}
+	if port, ok := assignments[mountDirPath]; ok && (portRange == "" || (port >= first && port <= last)) {
+		return port, nil
+	}
+
+	assigned := make(map[int]bool)
+	for otherMountDirPath, port := range assignments {
+		if otherMountDirPath != mountDirPath {
+			assigned[port] = true
+		}
+	}
+	port := 0
+	if portRange == "" {
+		for i := 0; i < maxEphemeralPortAttempts && port == 0; i++ {
+			ephemeralPort, err := getEphemeralPortUDP()
+			if err != nil {
+				return 0, err
+			}
+			if !assigned[ephemeralPort] {
+				port = ephemeralPort
+			}
+		}
+		if port == 0 {
+			return 0, errors.New("failed to find an unassigned ephemeral port")
+		}
+	} else {
+		for candidate := first; candidate <= last && port == 0; candidate++ {
+			if !assigned[candidate] && a.isPortFree(candidate) {
+				port = candidate
+			}
+		}
+		if port == 0 {
+			return 0, errors.WithStack(portRangeExhaustedError{portRange: portRange})
+		}
+	}
+	assignments[mountDirPath] = port
+	if err := a.save(assignments); err != nil {
+		return 0, err
+	}
+	return port, nil
+}
+
+// releaseIfConflicting drops the assignment for mountDirPath if another process has bound its port (e.g. after a
+// BeeGFS mount failed because the port was taken between allocation and mount). It returns true if it dropped the
+// assignment, in which case the next call to allocate selects a different port.
+func (a *udpPortAllocator) releaseIfConflicting(mountDirPath string) (bool, error) {
+	unlock, err := a.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	assignments, err := a.load()
+	if err != nil {
+		return false, err
+	}
+	port, ok := assignments[mountDirPath]
+	if !ok || a.isPortFree(port) {
+		return false, nil
+	}
+	delete(assignments, mountDirPath)
+	return true, a.save(assignments)
+}
+
+// lock acquires exclusive access to the state file. The returned function releases it.
+func (a *udpPortAllocator) lock() (unlock func(), err error) {
+	a.mutex.Lock()
+	if err := fs.MkdirAll(path.Dir(a.statePath), 0750); err != nil {
+		a.mutex.Unlock()
+		return nil, errors.WithStack(err)
+	}
+	lockFile, err := fs.OpenFile(a.statePath+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		a.mutex.Unlock()
+		return nil, errors.Wrap(err, "error opening UDP port state lock file")
+	}
+	// Only a real file can be locked across processes (tests use an in-memory file system).
+	if osFile, ok := lockFile.(*os.File); ok {
+		if err := syscall.Flock(int(osFile.Fd()), syscall.LOCK_EX); err != nil {
+			_ = lockFile.Close()
+			a.mutex.Unlock()
+			return nil, errors.Wrap(err, "error locking UDP port state lock file")
+		}
+	}
+	return func() {
+		_ = lockFile.Close() // also releases the flock
+		a.mutex.Unlock()
+	}, nil
+}
+
+// load returns the persisted assignments of ports to mountDirPaths, excluding those whose mountDirPath no longer
+// exists.
+func (a *udpPortAllocator) load() (map[string]int, error) {
+	assignments := make(map[string]int)
+	stateBytes, err := fsutil.ReadFile(a.statePath)
+	if os.IsNotExist(err) {
+		return assignments, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error reading UDP port state file")
+	}
+	if err := json.Unmarshal(stateBytes, &assignments); err != nil {
+		return nil, errors.Wrap(err, "error parsing UDP port state file")
+	}
+	for mountDirPath := range assignments {
+		if _, err := fs.Stat(mountDirPath); os.IsNotExist(err) {
+			delete(assignments, mountDirPath)
+		}
+	}
+	return assignments, nil
+}
+
+// save atomically replaces the persisted assignments.
+func (a *udpPortAllocator) save(assignments map[string]int) error {
+	stateBytes, err := json.Marshal(assignments)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tmpPath := a.statePath + ".tmp"
+	if err := fsutil.WriteFile(tmpPath, stateBytes, 0644); err != nil {
+		return errors.Wrap(err, "error writing UDP port state file")
+	}
+	if err := fs.Rename(tmpPath, a.statePath); err != nil {
+		return errors.Wrap(err, "error writing UDP port state file")
+	}
+	return nil
+}
+
+// parsePortRange parses a connClientPortUDPRange like "40000-40999" (or a single port like "40000"). An empty
+// portRange is valid and results in zeros.
+func parsePortRange(portRange string) (first, last int, err error) {
+	if portRange == "" {
+		return 0, 0, nil
+	}
+	firstString, lastString := portRange, portRange
+	if i := strings.Index(portRange, "-"); i >= 0 {
+		firstString, lastString = portRange[:i], portRange[i+1:]
+	}
+	first, firstErr := strconv.Atoi(strings.TrimSpace(firstString))
+	last, lastErr := strconv.Atoi(strings.TrimSpace(lastString))
+	if firstErr != nil || lastErr != nil || first < 1 || last > 65535 || first > last {
+		return 0, 0, errors.Errorf("invalid connClientPortUDPRange %s", portRange)
+	}
+	return first, last, nil
+}
+
+// isPortFreeUDP returns true if port can be bound on all IPv4 addresses.
+func isPortFreeUDP(port int) bool {
+	conn, err := net.ListenPacket("udp4", ":"+strconv.Itoa(port))
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+// getEphemeralPortUDP returns the system-assigned ephemeral port of a temporary UDP/IPv4 socket bound to INADDR_ANY. It
+// only exists because BeeGFS does not support setting connClientPortUDP to zero. Another process may bind the returned
+// port before BeeGFS does. The node service recovers from that by releasing the conflicting assignment (see
+// releaseIfConflicting) and mounting again with a different port, up to maxMountAttempts times.
+func getEphemeralPortUDP() (port int, err error) {
+	conn, err := net.ListenPacket("udp4", "")
+	if err != nil {
+		err = errors.WithStack(err)
+		return 0, err
+	}
+	defer func() {
+		if closeErr := conn.Close(); closeErr != nil {
+			closeErr = errors.WithStack(closeErr)
+			if err != nil {
+				err = errors.WithMessage(err, closeErr.Error())
+			} else {
+				err = closeErr
+			}
+		}
+	}()
+	lAddr := conn.LocalAddr()
+	lUDPAddr, err := net.ResolveUDPAddr(lAddr.Network(), lAddr.String())
+	if err != nil {
+		err = errors.WithStack(err)
+		return 0, err
+	}
+	return lUDPAddr.Port, nil
+}
+
+// clientFilesErrorCode returns the gRPC code for an error returned by writeClientFiles.
+func clientFilesErrorCode(err error) codes.Code {
+	if errors.As(err, &portRangeExhaustedError{}) {
+		return codes.ResourceExhausted
+	}
+	return codes.Internal
+}
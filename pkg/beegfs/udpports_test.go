@@ -0,0 +1,127 @@
+/*
+Copyright 2021 NetApp, Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0.
+*/
+
+package beegfs
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"google.golang.org/grpc/codes"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := map[string]struct {
+		portRange           string
+		wantFirst, wantLast int
+		wantErr             bool
+	}{
+		"empty":          {portRange: ""},
+		"range":          {portRange: "40000-40999", wantFirst: 40000, wantLast: 40999},
+		"single port":    {portRange: "40000", wantFirst: 40000, wantLast: 40000},
+		"spaces":         {portRange: "40000 - 40999", wantFirst: 40000, wantLast: 40999},
+		"reversed":       {portRange: "40999-40000", wantErr: true},
+		"zero":           {portRange: "0-100", wantErr: true},
+		"too large":      {portRange: "65000-65536", wantErr: true},
+		"not a number":   {portRange: "low-high", wantErr: true},
+		"missing bound":  {portRange: "40000-", wantErr: true},
+		"too many parts": {portRange: "1-2-3", wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			first, last, err := parsePortRange(tc.portRange)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error to occur for %s", tc.portRange)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error to occur: %v", err)
+			}
+			if first != tc.wantFirst || last != tc.wantLast {
+				t.Fatalf("expected %d-%d, got: %d-%d", tc.wantFirst, tc.wantLast, first, last)
+			}
+		})
+	}
+}
+
+func TestGetEphemeralPortUDP(t *testing.T) {
+	_, err := getEphemeralPortUDP()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUDPPortAllocator(t *testing.T) {
+	fs = afero.NewMemMapFs() // test sets up its own, new, memory-mapped file system
+	fsutil = afero.Afero{Fs: fs}
+	const portRange = "40000-40003"
+	bound := map[int]bool{40002: true} // ports bound by other processes
+	newAllocator := func() *udpPortAllocator {
+		a := newUDPPortAllocator("/csDataDir")
+		a.isPortFree = func(port int) bool { return !bound[port] }
+		return a
+	}
+	for _, mountDirPath := range []string{"/csDataDir", "/vol1", "/vol2", "/vol3", "/vol4"} {
+		if err := fs.MkdirAll(mountDirPath, 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	allocate := func(a *udpPortAllocator, mountDirPath string, want int) {
+		t.Helper()
+		got, err := a.allocate(mountDirPath, portRange)
+		if err != nil {
+			t.Fatalf("expected no error to occur: %v", err)
+		}
+		if got != want {
+			t.Fatalf("expected port %d for %s, got: %d", want, mountDirPath, got)
+		}
+	}
+
+	a := newAllocator()
+	allocate(a, "/vol1", 40000)
+	allocate(a, "/vol1", 40000) // the same mountDirPath keeps its port
+	allocate(a, "/vol2", 40001)
+	allocate(a, "/vol3", 40003) // 40002 is bound by another process
+
+	// Every port is assigned or bound.
+	_, err := a.allocate("/vol4", portRange)
+	if !errors.As(err, &portRangeExhaustedError{}) {
+		t.Fatalf("expected portRangeExhaustedError, got: %v", err)
+	}
+	err = errors.WithMessage(err, "error selecting connClientPortUDP") // as returned by writeClientFiles
+	if code := clientFilesErrorCode(err); code != codes.ResourceExhausted {
+		t.Fatalf("expected code %s, got: %s", codes.ResourceExhausted, code)
+	}
+
+	// Assignments survive a restart.
+	a = newAllocator()
+	allocate(a, "/vol2", 40001)
+
+	// The assignment of a mountDirPath that was cleaned up is dropped.
+	if err := fs.RemoveAll("/vol1"); err != nil {
+		t.Fatal(err)
+	}
+	allocate(a, "/vol4", 40000)
+
+	// A port that another process bound after it was assigned is released.
+	if released, err := a.releaseIfConflicting("/vol2"); err != nil || released {
+		t.Fatalf("expected port for /vol2 not to be released, got: %t, %v", released, err)
+	}
+	bound[40001] = true
+	if released, err := a.releaseIfConflicting("/vol2"); err != nil || !released {
+		t.Fatalf("expected port for /vol2 to be released, got: %t, %v", released, err)
+	}
+	delete(bound, 40002)
+	allocate(a, "/vol2", 40002)
+
+	// A port outside of a changed range is replaced.
+	port, err := a.allocate("/vol2", "41000-41000")
+	if err != nil || port != 41000 {
+		t.Fatalf("expected port 41000, got: %d, %v", port, err)
+	}
+}